@@ -1,12 +1,13 @@
 package models
 
 import (
+	"context"
 	"encoding/json"
 	"math"
 	"math/rand"
+	"sort"
 
 	"github.com/jinzhu/gorm"
-	log "github.com/sirupsen/logrus"
 )
 
 // baseAgent agent.
@@ -14,6 +15,11 @@ type baseAgent interface {
 	playRound(<-chan board) board
 }
 
+// configurableAgent is configured from the agent it plays for.
+type configurableAgent interface {
+	configure(agentModel) baseAgent
+}
+
 // Slayer of chess
 //
 // Override the following method to provide choice options.
@@ -41,52 +47,23 @@ type scoredBoard struct {
 func (agent coreBaseAgent) evaluateBoards(boards <-chan board) <-chan scoredBoard {
 	out := make(chan scoredBoard)
 	go func() {
-		out <- scoredBoard{0, <-boards}
+		defer close(out)
 		for b := range boards {
-			if rand.Int() > 0 {
-				out <- scoredBoard{0, b}
-			}
+			out <- scoredBoard{0, b}
 		}
-		close(out)
 	}()
 	return out
 }
 
 // Play a game round
 func (agent coreBaseAgent) playRound(boards <-chan board) board {
-	scoredBoards := make(chan scoredBoard)
-	for i := 0; i < 4; i++ {
-		go func() {
-			for b := range agent.evaluateBoards(boards) {
-				scoredBoards <- b
-			}
-		}()
-	}
-	maxBoard := make(chan board)
-	go func() {
-		maxBoards := make([]board, 0)
-		max := -99
-		for b := range scoredBoards {
-			if b.score > max {
-				maxBoards = make([]board, 0)
-				maxBoards = append(maxBoards, b.board)
-				max = b.score
-			} else if b.score == max {
-				maxBoards = append(maxBoards, b.board)
-			}
-		}
-		for _, b := range maxBoards {
-			maxBoard <- b
-		}
-		close(maxBoard)
-	}()
-	out := <-maxBoard
-	for b := range maxBoard {
-		if rand.Int() > 0 {
-			out = b
-		}
-	}
-	return out
+	return bestScored(agent.evaluateBoards(boards), agent.rng)
+}
+
+// Random source of the agent, seeded again for each move.
+func (agent coreBaseAgent) configure(model agentModel) baseAgent {
+	agent.rng = rand.New(rand.NewSource(model.Seed))
+	return agent
 }
 
 type pieceValues struct {
@@ -144,6 +121,9 @@ func getScore(leaf board, posY int, posX int, piece uint8, valueMap map[string]p
 // ZEROSquares = <board_matrix>
 type weightAgent struct {
 	coreBaseAgent
+	// Moves in each sequence, two when not configured.
+	lookahead int
+
 	OwnPAWNVal   int
 	OwnKNIGHTVal int
 	OwnBISHOPVal int
@@ -174,8 +154,9 @@ type weightAgent struct {
 	ZEROSquares      [8][8]int
 }
 
+// Weighted value of the leaf of a sequence for the mover.
 func (agent weightAgent) checkSequence(sequence []board, valueMap map[string]pieceValues) int {
-	leaf := sequence[len(sequence)-2]
+	leaf := sequence[len(sequence)-1]
 	sum := 0
 	for posY, r := range leaf {
 		for posX, piece := range r {
@@ -185,27 +166,42 @@ func (agent weightAgent) checkSequence(sequence []board, valueMap map[string]pie
 	return sum
 }
 
+// Score a root board by minimax over its sequences, the opponent picks the
+// worst reply and the mover its best move after it.
 func (agent weightAgent) sequenceGrouper(root board, sequences [][]board, valueMap map[string]pieceValues) scoredBoard {
-	rootValue := 0
-	for _, sequence := range sequences {
-		if rand.Int() > 0 {
-			rootValue = agent.checkSequence(sequence, valueMap)
-		}
+	if matedRoot(sequences, agent.depth()) {
+		return scoredBoard{math.MaxInt32, root}
 	}
+	rootValue := agent.sequenceValue(sequences, 1, valueMap)
 	return scoredBoard{int(math.Round(float64(rootValue) / 100)), root}
 }
 
-// Determine value for each board state in array of board states
-//
-// Inputs:
-//
-//	boards: Array of board states
+// Minimax value of sequences sharing their boards before ply, listed in
+// order so the sequences through each board of ply are adjacent.
 //
-// Outputs:
-//
-//	best_state: The highest valued board state in the array
-func (agent weightAgent) evaluateBoards(boards <-chan board) <-chan scoredBoard {
-	// Pair encoded pieces to values
+// The opponent plays the odd plies and the mover the even ones, a sequence
+// ending before ply is valued at its last board.
+func (agent weightAgent) sequenceValue(sequences [][]board, ply int, valueMap map[string]pieceValues) int {
+	if len(sequences[0]) <= ply {
+		return agent.checkSequence(sequences[0], valueMap)
+	}
+	best := 0
+	for start := 0; start < len(sequences); {
+		end := start + 1
+		for end < len(sequences) && sequences[end][ply] == sequences[start][ply] {
+			end++
+		}
+		value := agent.sequenceValue(sequences[start:end], ply+1, valueMap)
+		if start == 0 || ply%2 == 1 && value < best || ply%2 == 0 && value > best {
+			best = value
+		}
+		start = end
+	}
+	return best
+}
+
+// Pair encoded pieces to values.
+func (agent weightAgent) valueMap() map[string]pieceValues {
 	valueMap := make(map[string]pieceValues)
 
 	valueMap["OwnPAWN"] = pieceValues{agent.OwnPAWNVal, agent.OwnPAWNSquares}
@@ -224,34 +220,151 @@ func (agent weightAgent) evaluateBoards(boards <-chan board) <-chan scoredBoard
 
 	valueMap["EMPTY_SPACE"] = pieceValues{50, agent.ZEROSquares}
 
+	return valueMap
+}
+
+// Determine value for each board state in array of board states
+//
+// Inputs:
+//
+//	boards: Array of board states
+//
+// Outputs:
+//
+//	scored boards: Each board state with its value, in input order
+func (agent weightAgent) evaluateBoards(boards <-chan board) <-chan scoredBoard {
+	valueMap := agent.valueMap()
 	scoredBoards := make(chan scoredBoard)
 	go func() {
-		for b := range boards {
-			sequences := make([][]board, 0)
-			scoredBoards <- agent.sequenceGrouper(b, sequences, valueMap)
+		defer close(scoredBoards)
+		for root := range boards {
+			scoredBoards <- agent.sequenceGrouper(root, lookaheadSequences(root, agent.depth()), valueMap)
 		}
 	}()
+	return scoredBoards
+}
+
+// Play the board with the best value, ties are broken by the random source.
+func (agent weightAgent) playRound(boards <-chan board) board {
+	return bestScored(agent.evaluateBoards(boards), agent.rng)
+}
+
+// Sequences look as far ahead as the agent, up to maxLookahead moves.
+func (agent weightAgent) configure(model agentModel) baseAgent {
+	agent.coreBaseAgent = agent.coreBaseAgent.configure(model).(coreBaseAgent)
+	agent.lookahead = model.Lookahead
+	if agent.lookahead > maxLookahead {
+		agent.lookahead = maxLookahead
+	}
+	return agent
+}
+
+// Moves in each lookahead sequence.
+func (agent weightAgent) depth() int {
+	if agent.lookahead < 1 {
+		return 2
+	}
+	return agent.lookahead
+}
 
-	maxBoard := make(chan scoredBoard)
+// Score the root of each group of sequences listed together by the game.
+func scoreSequences(sequences [][]board, grouper func(board, [][]board, map[string]pieceValues) scoredBoard, valueMap map[string]pieceValues) <-chan scoredBoard {
+	scoredBoards := make(chan scoredBoard)
 	go func() {
-		maxBoards := make([]board, 0)
-		max := -99
-		for b := range scoredBoards {
-			if b.score > max {
-				maxBoards = make([]board, 0)
-				maxBoards = append(maxBoards, b.board)
-				max = b.score
-			} else if b.score == max {
-				maxBoards = append(maxBoards, b.board)
+		defer close(scoredBoards)
+		for start := 0; start < len(sequences); {
+			root := sequences[start][0]
+			end := start + 1
+			for end < len(sequences) && sequences[end][0] == root {
+				end++
 			}
+			scoredBoards <- grouper(root, sequences[start:end], valueMap)
+			start = end
 		}
-		for _, b := range maxBoards {
-			maxBoard <- scoredBoard{max, b}
-		}
-		close(maxBoard)
 	}()
+	return scoredBoards
+}
+
+// Best scored board, a random one of the ties or the first one without a
+// random source.
+func bestScored(scoredBoards <-chan scoredBoard, rng *rand.Rand) board {
+	best := make([]board, 0, 8)
+	max := 0
+	for scored := range scoredBoards {
+		if len(best) == 0 || scored.score > max {
+			best = append(best[:0], scored.board)
+			max = scored.score
+		} else if scored.score == max {
+			best = append(best, scored.board)
+		}
+	}
+	if len(best) == 0 {
+		return board{}
+	}
+	if rng == nil {
+		return best[0]
+	}
+	return best[rng.Intn(len(best))]
+}
+
+// Play of the weight agents from the sequences listed by the game, with the
+// moves in each sequence.
+//
+// Search agents embed a weight agent but search on their own, so the agents
+// are matched by type.
+func sequencePlayer(delegate baseAgent) (func([][]board) board, int, bool) {
+	switch agent := delegate.(type) {
+	case weightAgent:
+		return func(sequences [][]board) board {
+			return bestScored(scoreSequences(sequences, agent.sequenceGrouper, agent.valueMap()), agent.rng)
+		}, agent.depth(), true
+	case baseStrategyAgent:
+		return func(sequences [][]board) board {
+			return bestScored(scoreSequences(sequences, agent.sequenceGrouper, agent.valueMap()), agent.rng)
+		}, agent.depth(), true
+	}
+	return nil, 0, false
+}
+
+// Most moves in a lookahead sequence.
+const maxLookahead = 3
 
-	return maxBoard
+// Sequences of depth moves from a root board, from the mover view.
+//
+// A sequence ends early once the side to move has no moves.
+func lookaheadSequences(root board, depth int) [][]board {
+	return appendSequences(nil, []board{root}, depth-1, true)
+}
+
+// Append the sequences continuing a prefix for plies more moves, the last
+// board was played by the mover or by the opponent.
+func appendSequences(sequences [][]board, prefix []board, plies int, moverPlayed bool) [][]board {
+	if plies <= 0 {
+		return append(sequences, prefix)
+	}
+	last := prefix[len(prefix)-1]
+	var next []board
+	if moverPlayed {
+		for _, reply := range nextBoards(swap(last)) {
+			next = append(next, swap(reply))
+		}
+	} else {
+		next = nextBoards(last)
+	}
+	if len(next) == 0 {
+		return append(sequences, prefix)
+	}
+	for _, b := range next {
+		sequence := make([]board, len(prefix), len(prefix)+1)
+		copy(sequence, prefix)
+		sequences = appendSequences(sequences, append(sequence, b), plies-1, !moverPlayed)
+	}
+	return sequences
+}
+
+// The root of the sequences leaves the opponent mated.
+func matedRoot(sequences [][]board, depth int) bool {
+	return depth > 1 && len(sequences) == 1 && len(sequences[0]) == 1 && lookaheadCheck(sequences[0][0])
 }
 
 // class WeightAgent(BaseAgent):
@@ -591,64 +704,109 @@ type strategyAgent interface {
 	strategy([]int) int
 }
 
-type baseStrategyAgent struct {
-	coreBaseAgent
+// Strategy from a function of the sequence values.
+type valueStrategy func([]int) int
+
+func (strategy valueStrategy) strategy(values []int) int {
+	return strategy(values)
 }
 
-// STRATEGY = harmonic_mean
-func (agent baseStrategyAgent) strategy(values []int) int {
-	return values[0]
+// Harmonic mean of the values, a value that is not positive makes it zero.
+func harmonicPositive(values []int) int {
+	if len(values) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, value := range values {
+		if value <= 0 {
+			return 0
+		}
+		sum += 1 / float64(value)
+	}
+	return int(math.Round(float64(len(values)) / sum))
 }
 
-func (agent baseStrategyAgent) checkSequence(sequence []board, valueMap map[string]pieceValues) int {
-	leaf := sequence[len(sequence)-2]
-	sum := 0
-	for posY, r := range leaf {
-		for posX, piece := range r {
-			sum += getScore(leaf, posY, posX, piece, valueMap)
+func minValue(values []int) int {
+	out := values[0]
+	for _, value := range values[1:] {
+		if value < out {
+			out = value
 		}
 	}
-	return sum
+	return out
+}
+
+func maxValue(values []int) int {
+	out := values[0]
+	for _, value := range values[1:] {
+		if value > out {
+			out = value
+		}
+	}
+	return out
+}
+
+// Scores each root board with its strategy over the weighted leaves of its
+// lookahead sequences.
+type baseStrategyAgent struct {
+	weightAgent
+	strategyAgent
+}
+
+func newStrategyAgent(weights weightAgent, strategy valueStrategy) baseStrategyAgent {
+	return baseStrategyAgent{weights, strategy}
 }
 
 func (agent baseStrategyAgent) sequenceGrouper(root board, sequences [][]board, valueMap map[string]pieceValues) scoredBoard {
-	values := make([]int, len(sequences))
+	if matedRoot(sequences, agent.depth()) {
+		return scoredBoard{math.MaxInt32, root}
+	}
+	values := make([]int, 0, len(sequences))
 	for _, sequence := range sequences {
-		if rand.Int() > 0 {
-			values = append(values, agent.checkSequence(sequence, valueMap))
-		}
+		values = append(values, agent.checkSequence(sequence, valueMap))
 	}
 	rootValue := agent.strategy(values)
 	return scoredBoard{int(math.Round(float64(rootValue) / 100)), root}
 }
 
-// BaseAgent Computer Agent.
-type coreBaseAgent struct{}
+func (agent baseStrategyAgent) evaluateBoards(boards <-chan board) <-chan scoredBoard {
+	valueMap := agent.valueMap()
+	scoredBoards := make(chan scoredBoard)
+	go func() {
+		defer close(scoredBoards)
+		for root := range boards {
+			scoredBoards <- agent.sequenceGrouper(root, lookaheadSequences(root, agent.depth()), valueMap)
+		}
+	}()
+	return scoredBoards
+}
 
-// class BalanceAgent(StrategyAgent, BalanceWeightAgent):
-//     """Computer Agent"""
-//     def STRATEGY(values):
-//         return harmonic_mean(map(lambda value: value if value > 0 else 0, values))
-//
-//
-// class NewAgent(StrategyAgent, BalanceWeightAgent):
-//     """Computer Agent"""
-//     STRATEGY = min
-//
-//
-// class MaxBalanceAgent(StrategyAgent, BalanceWeightAgent):
-//     """Computer Agent"""
-//     STRATEGY = max
-//
-//
-// class MaxPositiveAgent(StrategyAgent, PositiveWeightAgent):
-//     """Computer Agent"""
-//     STRATEGY = max
-//
+// Play the root board with the best strategy value, ties are broken by the
+// random source.
+func (agent baseStrategyAgent) playRound(boards <-chan board) board {
+	return bestScored(agent.evaluateBoards(boards), agent.rng)
+}
+
+func (agent baseStrategyAgent) configure(model agentModel) baseAgent {
+	agent.weightAgent = agent.weightAgent.configure(model).(weightAgent)
+	return agent
+}
+
+// BaseAgent Computer Agent.
 //
-// class MinPositiveAgent(StrategyAgent, PositiveWeightAgent):
-//     """Computer Agent"""
-//     STRATEGY = min
+// rng is the random source of a configured agent.
+type coreBaseAgent struct {
+	rng *rand.Rand
+}
+
+// Strategy agents as ported from the original Python agents.
+var (
+	balanceAgent     = newStrategyAgent(balanceWeightAgent(), harmonicPositive)
+	newAgent         = newStrategyAgent(balanceWeightAgent(), minValue)
+	maxBalanceAgent  = newStrategyAgent(balanceWeightAgent(), maxValue)
+	maxPositiveAgent = newStrategyAgent(positiveWeightAgent(), maxValue)
+	minPositiveAgent = newStrategyAgent(positiveWeightAgent(), minValue)
+)
 
 // UserAgentDelegate Human Agent
 type userAgentDelegate struct{}
@@ -660,32 +818,52 @@ type UserMoveMessage struct {
 
 func getMove(decoder *json.Decoder) [2][2]int {
 	var message UserMoveMessage
-	err := decoder.Decode(&message)
-	if err != nil {
-		log.Panicln(err)
-	}
+	decodeMessage(decoder, &message)
 	return message.Move
 }
 
 // PlayRound Play a game round
-func (userAgentDelegate) playRound(decoder *json.Decoder, agent agentModel, db *gorm.DB) BoardStateMessage {
+func (userAgentDelegate) playRound(ctx context.Context, decoder *json.Decoder, agent agentModel, db *gorm.DB) BoardStateMessage {
 	move := getMove(decoder)
-	proposal := agent.GetState(decoder)
+	proposal := agent.GetState(ctx, decoder)
 	if proposal.End {
 		return proposal
 	}
-	out := proposal.State
-	out[move[1][0]][move[1][1]] = out[move[0][0]][move[0][1]]
-	out[move[0][0]][move[0][1]] = 0
-	return agent.putBoard(out)
+	return agent.putBoard(ctx, userMove(proposal.State, move))
+}
+
+// Board after a user move of a piece from one row and column to another.
+//
+// Pawns reaching the last row promote to queens.
+func userMove(state board, move [2][2]int) board {
+	state[move[1][0]][move[1][1]] = state[move[0][0]][move[0][1]]
+	state[move[0][0]][move[0][1]] = 0
+	if move[1][0] == 0 && state[0][move[1][1]]&0xF == PAWN|1 {
+		state[0][move[1][1]] = QUEEN | 1
+	}
+	return state
+}
+
+// AgentNames of the registered computer agents, sorted.
+func AgentNames() []string {
+	names := make([]string, 0, len(agents))
+	for name := range agents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
 // agents agents.
 var agents = map[string]baseAgent{
-	"base-agent": coreBaseAgent{},
-	// "balance-agent":      BalanceAgent,
-	// "new-agent":          NewAgent,
-	// "max-balance-agent":  MaxPositiveAgent,
-	// "max-positive-agent": MaxPositiveAgent,
-	// "min-positive-agent": MinPositiveAgent,
+	"base-agent":           coreBaseAgent{},
+	"search-agent":         newSearchAgent(positiveWeightAgent(), defaultSearchDepth),
+	"tapered-search-agent": newSearchAgent(balanceWeightAgent(), defaultSearchDepth).withEvaluator(newTaperedEvaluator()),
+	"mcts-agent":           newMCTSAgent(positiveWeightAgent(), true),
+	"mcts-random-agent":    newMCTSAgent(positiveWeightAgent(), false),
+	"balance-agent":        balanceAgent,
+	"new-agent":            newAgent,
+	"max-balance-agent":    maxBalanceAgent,
+	"max-positive-agent":   maxPositiveAgent,
+	"min-positive-agent":   minPositiveAgent,
 }
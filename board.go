@@ -1,10 +1,11 @@
 package models
 
 import (
-	"bytes"
 	"encoding/json"
-	"net/http"
 	"net/url"
+	"os"
+	"strconv"
+	"sync"
 
 	"github.com/jinzhu/gorm"
 	uuid "github.com/satori/go.uuid"
@@ -12,13 +13,45 @@ import (
 )
 
 // GameJoinMessage board
+//
+// AgentURL receives a PUT of the game state when it is the agent's turn.
 type GameJoinMessage struct {
-	AgentURL url.URL
+	AgentURL string
 	ID       uuid.UUID
 }
 
+var (
+	database     *gorm.DB
+	databaseOnce sync.Once
+)
+
+// Path of the sqlite database, override with CHESS_DB.
+func databasePath() string {
+	if path := os.Getenv("CHESS_DB"); path != "" {
+		return path
+	}
+	return "chess.db"
+}
+
+// New game, agent and cursor IDs.
+func newID() uuid.UUID {
+	return uuid.NewV4()
+}
+
+// Validate an ID as issued by newID.
+func validID(ID uuid.UUID) bool {
+	return ID.Version() == uuid.V4 && ID.Variant() == uuid.VariantRFC4122
+}
+
 func openDB() *gorm.DB {
-	db, err := gorm.Open("sqlite3", "chess.db")
+	databaseOnce.Do(func() {
+		database = migrateDB()
+	})
+	return database.Begin()
+}
+
+func migrateDB() *gorm.DB {
+	db, err := gorm.Open("sqlite3", databasePath()+"?_busy_timeout=10000")
 	if err != nil {
 		log.Panicln("failed to connect database", err, connStr)
 	}
@@ -42,11 +75,31 @@ func openDB() *gorm.DB {
 	}
 	tx.AutoMigrate(&boardModel{})
 
+	if !tx.HasTable(&moveModel{}) {
+		tx.CreateTable(&moveModel{})
+	}
+	tx.AutoMigrate(&moveModel{})
+
+	if !tx.HasTable(&deliveryModel{}) {
+		tx.CreateTable(&deliveryModel{})
+	}
+	tx.AutoMigrate(&deliveryModel{})
+
 	if !tx.HasTable(&agentModel{}) {
 		tx.CreateTable(&agentModel{})
 	}
 	tx.AutoMigrate(&agentModel{})
 
+	if !tx.HasTable(&ratingModel{}) {
+		tx.CreateTable(&ratingModel{})
+	}
+	tx.AutoMigrate(&ratingModel{})
+
+	if !tx.HasTable(&ratingHistoryModel{}) {
+		tx.CreateTable(&ratingHistoryModel{})
+	}
+	tx.AutoMigrate(&ratingHistoryModel{})
+
 	commitDB(tx)
 
 	if errors := db.GetErrors(); len(errors) != 0 {
@@ -57,7 +110,7 @@ func openDB() *gorm.DB {
 		panic(errors)
 	}
 
-	return db.Begin()
+	return db
 }
 
 func commitDB(db *gorm.DB) {
@@ -68,9 +121,21 @@ func commitDB(db *gorm.DB) {
 	db.Commit()
 }
 
+// Run fn in a transaction, rolling back if it panics.
+func transaction(fn func(db *gorm.DB)) {
+	db := openDB()
+	defer func() {
+		if r := recover(); r != nil {
+			db.Rollback()
+			panic(r)
+		}
+	}()
+	fn(db)
+	commitDB(db)
+}
+
 func closeDB(db *gorm.DB) {
 	defer func() {
-		defer db.Close()
 		if r := recover(); r != nil {
 			db.Rollback()
 			panic(r)
@@ -82,10 +147,13 @@ func closeDB(db *gorm.DB) {
 // Board board.
 type Board interface {
 	AddPlayer(decoder *json.Decoder) BoardStateMessage
-	GetInfo(decoder *json.Decoder) BoardStateMessage
+	GetInfo(values url.Values) BoardInfoMessage
 	GetState(values url.Values) BoardStateMessage
-	GetStates(decoder *json.Decoder) BoardStateMessage
+	GetStates(values url.Values) CursorMessage
 	PlayRound(decoder *json.Decoder) BoardStateMessage
+	GetBoard(values url.Values) AbsoluteStateMessage
+	PlayMove(decoder *json.Decoder) AbsoluteStateMessage
+	GetDeliveries() DeliveriesMessage
 }
 
 // BoardInfoMessage board.
@@ -103,32 +171,43 @@ func MakeGame(decoder *json.Decoder) BoardCreatedMessage {
 	db := openDB()
 	defer closeDB(db)
 	var game boardModel
-	game.ID = uuid.NewV5(uuid.NamespaceOID, "chess.board")
+	game.ID = newID()
 	game.State = initialBoard
 	db.Create(&game)
+	events.publishTo(uuid.UUID{}, GameEventMessage{Game: game.ID, Type: createEvent, State: game.State})
 	return BoardCreatedMessage{game.ID}
 }
 
+// NoBoard error.
+type NoBoard struct{}
+
+func (err NoBoard) Error() string {
+	return "No game found."
+}
+
+// GameFull error.
+type GameFull struct{}
+
+func (err GameFull) Error() string {
+	return "Game is full."
+}
+
 // GetGame game.
 func GetGame(ID uuid.UUID) Board {
 	db := openDB()
 	defer closeDB(db)
 	var game boardModel
-	rows, err := db.First(&game, "id = ?", ID).Rows()
-	if err != nil {
-		log.Panicln(err)
+	err := db.First(&game, "id = ?", ID).Error
+	if gorm.IsRecordNotFoundError(err) {
+		panic(NoBoard{})
 	}
-	if !rows.Next() {
-		log.Panicln(rows)
-	}
-	err = rows.Scan(&game.ID, &game.CreatedAt, &game.UpdatedAt, &game.DeletedAt, &game.State, &game.MoveCount, &game.MovesSincePawn, &game.Player1, &game.Player2)
 	if err != nil {
 		log.Panicln(err)
 	}
 	if game.ID != ID {
 		panic(game)
 	}
-	if game.ID.Version() != uuid.V5 {
+	if !validID(game.ID) {
 		panic(game)
 	}
 	return &game
@@ -138,8 +217,7 @@ func GetGame(ID uuid.UUID) Board {
 func GetGames(decoder *json.Decoder) BoardStatesMessage {
 	db := openDB()
 	defer closeDB(db)
-	var game boardModel
-	rows, err := db.Find(&game).Rows()
+	rows, err := db.Model(&boardModel{}).Rows()
 	if err != nil {
 		log.Panicln("Failed to get game rows", err)
 	}
@@ -147,7 +225,7 @@ func GetGames(decoder *json.Decoder) BoardStatesMessage {
 	games := make([]uuid.UUID, 0)
 	for rows.Next() {
 		var game boardModel
-		err := rows.Scan(&game.ID, &game.CreatedAt, &game.UpdatedAt, &game.DeletedAt, &game.State, &game.MoveCount, &game.MovesSincePawn, &game.Player1, &game.Player2)
+		err := db.ScanRows(rows, &game)
 		if err != nil {
 			log.Panicln("Failed to scan row", err)
 		}
@@ -164,57 +242,80 @@ func (board boardModel) stateMessage() BoardStateMessage {
 	return BoardStateMessage{End: !board.active(), State: board.State}
 }
 
+// StaleGame error.
+type StaleGame struct{}
+
+func (err StaleGame) Error() string {
+	return "Game was modified by another request."
+}
+
+// Save game if no other update was saved since it was loaded.
+func (board *boardModel) save(db *gorm.DB) {
+	version := board.Version
+	result := db.Model(board).Where("version = ?", version).Updates(map[string]interface{}{
+		"state":            board.State,
+		"move_count":       board.MoveCount,
+		"moves_since_pawn": board.MovesSincePawn,
+		"player1":          board.Player1,
+		"player2":          board.Player2,
+		"version":          version + 1,
+		"end_reason":       board.EndReason,
+		"player1_joined":   board.Player1Joined,
+		"player2_joined":   board.Player2Joined,
+		"player1_url":      board.Player1URL,
+		"player2_url":      board.Player2URL,
+	})
+	if result.Error != nil {
+		log.Panicln(result.Error)
+	}
+	if result.RowsAffected != 1 {
+		panic(StaleGame{})
+	}
+	board.Version = version + 1
+}
+
 // AddPlayer to board.
 func (board *boardModel) AddPlayer(decoder *json.Decoder) BoardStateMessage {
-	if board.Player2.Version() == uuid.V5 {
-		log.Panicln("Game is full.")
+	if validID(board.Player2) {
+		panic(GameFull{})
 	}
 	var message GameJoinMessage
-	err := decoder.Decode(&message)
-	if err != nil {
-		log.Panicln(err)
+	decodeMessage(decoder, &message)
+	if !validID(message.ID) {
+		panic(InvalidMessage{"Invalid agent ID."})
 	}
-	if message.ID.Version() != uuid.V5 {
-		log.Panicln("Invalid agent ID")
+	if board.Player1 == message.ID {
+		panic(InvalidMessage{"Agent already joined."})
 	}
-	if board.Player1.Version() != uuid.V5 {
-		board.Player1 = message.ID
+	if message.AgentURL != "" {
+		callback, err := url.Parse(message.AgentURL)
+		if err != nil || !callback.IsAbs() {
+			panic(InvalidMessage{"Invalid agent URL."})
+		}
+	}
+	next := *board
+	if !validID(next.Player1) {
+		next.Player1 = message.ID
+		next.Player1URL = message.AgentURL
+		next.Player1Joined = board.Version + 1
 	} else {
-		board.Player2 = message.ID
+		next.Player2 = message.ID
+		next.Player2URL = message.AgentURL
+		next.Player2Joined = board.Version + 1
+	}
+	transaction(next.save)
+	*board = next
+	events.publish(board.event(joinEvent, message.ID))
+	if validID(board.Player2) {
 		// Player 2 joins game.
 		board.pokePlayer(board.Player1)
 	}
 	return board.stateMessage()
 }
 
-// Inform active player of game state.
-func (board boardModel) pokePlayer(player uuid.UUID) {
-	data, err := json.Marshal(board.stateMessage())
-	if err != nil {
-		log.Panicln(err)
-	}
-	req, err := http.NewRequest(http.MethodPut, "", bytes.NewReader(data))
-	if err != nil {
-		log.Panicln(err)
-	}
-	defer req.Body.Close()
-	var client http.Client
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Panicln(err)
-	}
-	defer resp.Body.Close()
-	var message BoardStateMessage
-	err = json.NewDecoder(resp.Body).Decode(&message)
-	if err != nil {
-		log.Panicln(err)
-	}
-	// self.request("PUT", f"/agent/{ active_player or self.active_player() }", json={"end": end})
-}
-
 // GetInfo game.
-func (board boardModel) GetInfo(decoder *json.Decoder) BoardStateMessage {
-	return BoardStateMessage{}
+func (board boardModel) GetInfo(values url.Values) BoardInfoMessage {
+	return BoardInfoMessage{board.print(values)}
 }
 
 // GetState game.
@@ -223,13 +324,76 @@ func (board boardModel) GetState(values url.Values) BoardStateMessage {
 }
 
 // GetStates game.
-func (board boardModel) GetStates(decoder *json.Decoder) BoardStateMessage {
-	return BoardStateMessage{}
+//
+// Lists the legal next boards, as they are played, a slice at a time. With
+// a lookahead above one the sequences of each next board are listed.
+func (board boardModel) GetStates(values url.Values) CursorMessage {
+	lookahead := 1
+	if param := values.Get("lookahead"); param != "" {
+		var err error
+		lookahead, err = strconv.Atoi(param)
+		if err != nil || lookahead < 1 || lookahead > maxLookahead {
+			panic(InvalidMessage{"lookahead must be between 1 and " + strconv.Itoa(maxLookahead)})
+		}
+	}
+	var cursor uuid.UUID
+	if param := values.Get("cursor"); param != "" {
+		var err error
+		cursor, err = uuid.FromString(param)
+		if err != nil {
+			panic(InvalidMessage{"cursor must be a UUID"})
+		}
+	}
+	return cursors.sliceCursorV1(board, cursor, lookahead)
 }
 
 // PlayRound game.
-func (board boardModel) PlayRound(decoder *json.Decoder) BoardStateMessage {
-	return BoardStateMessage{}
+func (board *boardModel) PlayRound(decoder *json.Decoder) BoardStateMessage {
+	var play PlayMessage
+	decodeMessage(decoder, &play)
+	return board.play(play.State)
+}
+
+// Validate and save the next state.
+func (board *boardModel) play(state board) (message BoardStateMessage) {
+	if !board.active() {
+		return board.stateMessage()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(InvalidMove); !ok {
+				panic(r)
+			}
+			message = board.stateMessage()
+			message.Invalid = true
+		}
+	}()
+	mover := board.activePlayer()
+	next := board.update(state)
+	if next.active() && len(nextBoards(next.State)) == 0 {
+		next.EndReason = noMovesReason(next.State)
+	}
+	if !next.active() {
+		// Recorded so finished games can be selected.
+		next.EndReason = next.endReason()
+	}
+	transaction(func(db *gorm.DB) {
+		next.save(db)
+		next.recordMove(db, mover)
+		if !next.active() {
+			next.rate(db)
+		}
+	})
+	*board = next
+	events.publish(board.event(moveEvent, mover))
+	if !board.active() {
+		events.publish(board.event(endEvent, mover))
+		board.pokePlayer(board.Player1)
+		board.pokePlayer(board.Player2)
+	} else {
+		board.pokePlayer(board.activePlayer())
+	}
+	return board.stateMessage()
 }
 
 // class BlankBoard:
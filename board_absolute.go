@@ -0,0 +1,141 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// Piece names indexed by piece type.
+var pieceNames = [7]string{"", "bishop", "king", "knight", "pawn", "queen", "rook"}
+
+// Colours.
+const (
+	white = "white"
+	black = "black"
+)
+
+// ColouredPiece board.
+type ColouredPiece struct {
+	Colour string
+	Piece  string
+}
+
+// AbsoluteStateMessage board.
+//
+// State has white at the bottom, rank 8 first, and the low bit of a piece set
+// for white pieces. Pieces maps algebraic squares to the piece on them.
+type AbsoluteStateMessage struct {
+	End, Invalid bool
+	Active       string
+	MoveCount    int
+	State        board
+	Pieces       map[string]ColouredPiece
+}
+
+// AbsoluteMoveMessage board.
+//
+// From and To are algebraic squares, Promotion names the piece a pawn
+// promotes to and defaults to queen.
+type AbsoluteMoveMessage struct {
+	From, To  string
+	Promotion string
+}
+
+// Convert a board from the active player view to white at the bottom with
+// the low bit marking white pieces.
+func absoluteBoard(state board, activeWhite bool) board {
+	if activeWhite {
+		return state
+	}
+	return swap(state)
+}
+
+// Convert a white at the bottom board back to the active player view.
+func relativeBoard(state board, activeWhite bool) board {
+	return absoluteBoard(state, activeWhite)
+}
+
+// Name of an algebraic square for absolute board indexes.
+func squareName(posX int, posY int) string {
+	return fmt.Sprintf("%c%c", 'a'+posX, '8'-posY)
+}
+
+// Absolute board indexes of an algebraic square.
+func parseSquare(square string) (int, int) {
+	if len(square) != 2 || square[0] < 'a' || square[0] > 'h' || square[1] < '1' || square[1] > '8' {
+		panic(InvalidMessage{fmt.Sprintf("Invalid square %q.", square)})
+	}
+	return int(square[0] - 'a'), int('8' - square[1])
+}
+
+func colourName(isWhite bool) string {
+	if isWhite {
+		return white
+	}
+	return black
+}
+
+// Describe the game with white at the bottom and explicit colours.
+func (board boardModel) absoluteStateMessage() AbsoluteStateMessage {
+	state := absoluteBoard(board.State, board.activeWhite())
+	pieces := make(map[string]ColouredPiece)
+	for posY, r := range state {
+		for posX, piece := range r {
+			if piece&0xE == 0 {
+				continue
+			}
+			pieces[squareName(posX, posY)] = ColouredPiece{colourName(piece&1 != 0), pieceNames[piece&0xE/2]}
+		}
+	}
+	return AbsoluteStateMessage{
+		End:       !board.active(),
+		Active:    colourName(board.activeWhite()),
+		MoveCount: board.MoveCount,
+		State:     state,
+		Pieces:    pieces,
+	}
+}
+
+// GetBoard game.
+func (board boardModel) GetBoard(values url.Values) AbsoluteStateMessage {
+	return board.absoluteStateMessage()
+}
+
+// PlayMove game.
+func (board *boardModel) PlayMove(decoder *json.Decoder) AbsoluteStateMessage {
+	var move AbsoluteMoveMessage
+	decodeMessage(decoder, &move)
+	message := board.play(board.absoluteMove(move))
+	out := board.absoluteStateMessage()
+	out.Invalid = message.Invalid
+	return out
+}
+
+// Convert an absolute move to the next state in the active player view.
+func (board boardModel) absoluteMove(move AbsoluteMoveMessage) board {
+	activeWhite := board.activeWhite()
+	state := absoluteBoard(board.State, activeWhite)
+	fromX, fromY := parseSquare(move.From)
+	toX, toY := parseSquare(move.To)
+	piece := state[fromY][fromX] & 0xF
+	state[fromY][fromX] = 0
+	if move.Promotion != "" {
+		promote := uint8(0)
+		for i, name := range pieceNames {
+			if name == move.Promotion {
+				promote = uint8(i * 2)
+			}
+		}
+		switch promote {
+		case BISHOP, KNIGHT, QUEEN, ROOK:
+			piece = promote | piece&1
+		default:
+			panic(InvalidMessage{fmt.Sprintf("Invalid promotion %q.", move.Promotion)})
+		}
+	} else if piece&0xE == PAWN && (toY == 0 && activeWhite || toY == 7 && !activeWhite) {
+		piece = QUEEN | piece&1
+	}
+	state[toY][toX] = piece
+	return relativeBoard(state, activeWhite)
+}
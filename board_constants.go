@@ -4,22 +4,39 @@ import (
 	"database/sql/driver"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"os"
 	"strings"
 )
 
 type board [8][8]uint8
 
+// BoardFormatError reports a malformed persisted or transmitted board.
+type BoardFormatError struct {
+	Row    int
+	Reason string
+}
+
+func (err BoardFormatError) Error() string {
+	if err.Row < 0 {
+		return fmt.Sprintf("Invalid board: %s.", err.Reason)
+	}
+	return fmt.Sprintf("Invalid board row %d: %s.", err.Row, err.Reason)
+}
+
 func (b *board) from(state [8]string) error {
+	var out board
 	for i, r := range state {
+		if len(r) != 16 {
+			return BoardFormatError{i, fmt.Sprintf("expected 16 hex digits, got %d", len(r))}
+		}
 		row, err := hex.DecodeString(r)
 		if err != nil {
-			return err
-		}
-		if len(row) != 8 {
-			return err
+			return BoardFormatError{i, err.Error()}
 		}
-		copy(b[i][:], row)
+		copy(out[i][:], row)
 	}
+	*b = out
 	return nil
 }
 
@@ -32,34 +49,193 @@ func (b board) to() [8]string {
 }
 
 func (b *board) UnmarshalJSON(bytes []byte) error {
-	var state [8]string
+	var state []string
 	if err := json.Unmarshal(bytes, &state); err != nil {
 		return err
 	}
-	return b.from(state)
+	if len(state) != 8 {
+		return BoardFormatError{-1, fmt.Sprintf("expected 8 rows, got %d", len(state))}
+	}
+	var rows [8]string
+	copy(rows[:], state)
+	return b.from(rows)
 }
 
+// Boards are stored in the compact binary encoding, hex encoded to fit the
+// text column, when CHESS_BOARD_ENCODING is compact. Scan reads either
+// encoding so existing rows stay readable.
+var compactColumns = os.Getenv("CHESS_BOARD_ENCODING") == "compact"
+
 func (b board) Value() (driver.Value, error) {
+	if compactColumns {
+		return compactBoard(b).Value()
+	}
 	state := b.to()
 	return strings.Join(state[:], ","), nil
 }
 
-func (b board) Scan(cell interface{}) error {
+// Scan reads either the comma separated hex text encoding or the compact
+// binary encoding, raw or hex encoded.
+func (b *board) Scan(cell interface{}) error {
 	switch cell := cell.(type) {
 	case string:
-		var state [8]string
-		copy(state[:], strings.Split(cell, ","))
-		b.from(state)
+		return b.scanText(cell)
+	case []byte:
+		if len(cell) == compactBoardSize {
+			return b.UnmarshalBinary(cell)
+		}
+		return b.scanText(string(cell))
+	case nil:
+		return BoardFormatError{-1, "NULL board"}
 	default:
-		return nil
+		return BoardFormatError{-1, fmt.Sprintf("unsupported column type %T", cell)}
 	}
-	return nil
+}
+
+func (b *board) scanText(cell string) error {
+	if len(cell) == 2*compactBoardSize {
+		data, err := hex.DecodeString(cell)
+		if err != nil {
+			return BoardFormatError{-1, err.Error()}
+		}
+		return b.UnmarshalBinary(data)
+	}
+	rows := strings.Split(cell, ",")
+	if len(rows) != 8 {
+		return BoardFormatError{-1, fmt.Sprintf("expected 8 rows, got %d", len(rows))}
+	}
+	var state [8]string
+	copy(state[:], rows)
+	return b.from(state)
 }
 
 func (b board) MarshalJSON() ([]byte, error) {
 	return json.Marshal(b.to())
 }
 
+// compactBoardSize is one nibble per square.
+const compactBoardSize = 32
+
+// Nibbles for unmoved rooks, the low four bits of an unmoved rook are already
+// taken by a moved rook.
+const (
+	compactRook       uint8 = 14
+	compactActiveRook uint8 = 15
+)
+
+// Drop castling flags that can never be used.
+//
+// A king flag is only useful with an unmoved rook of the same side and a rook
+// flag only with an unmoved king, so either one alone does not change any
+// future board state. Other pieces are left as they are.
+func (b board) canonical() board {
+	var kings, rooks [2]bool
+	for _, r := range b {
+		for _, piece := range r {
+			switch piece {
+			case KING | 0x10, KING | 0x11:
+				kings[piece&1] = true
+			case ROOK | 0x10, ROOK | 0x11:
+				rooks[piece&1] = true
+			}
+		}
+	}
+	out := b
+	for posY, r := range b {
+		for posX, piece := range r {
+			switch piece {
+			case KING | 0x10, KING | 0x11, ROOK | 0x10, ROOK | 0x11:
+				if side := piece & 1; !kings[side] || !rooks[side] {
+					out[posY][posX] = piece &^ 0x10
+				}
+			}
+		}
+	}
+	return out
+}
+
+// Compact binary encoding.
+//
+// Each square takes one nibble holding the low four bits of the piece,
+// unmoved rooks use the spare nibbles 14 and 15 and unmoved kings are implied
+// by an unmoved rook of the same side. Boards with castling flags that can
+// never be used do not decode to themselves and are rejected.
+func (b board) MarshalBinary() ([]byte, error) {
+	canonical := b.canonical()
+	for posY, r := range b {
+		if r != canonical[posY] {
+			return nil, BoardFormatError{posY, "castling flags not encodable without their king or rook"}
+		}
+	}
+	out := make([]byte, compactBoardSize)
+	for posY, r := range b {
+		for posX, piece := range r {
+			var nibble uint8
+			switch {
+			case piece&0xE0 != 0 || piece == 1 || piece&0xE == 0xE:
+				return nil, BoardFormatError{posY, fmt.Sprintf("piece %#x not encodable", piece)}
+			case piece == ROOK|0x10:
+				nibble = compactRook
+			case piece == ROOK|0x11:
+				nibble = compactActiveRook
+			case piece&0x10 != 0 && piece&0xE != KING:
+				return nil, BoardFormatError{posY, fmt.Sprintf("piece %#x not encodable", piece)}
+			default:
+				nibble = piece & 0xF
+			}
+			i := posY*8 + posX
+			out[i/2] |= nibble << uint(4*(i%2))
+		}
+	}
+	return out, nil
+}
+
+// UnmarshalBinary decodes the compact binary encoding.
+func (b *board) UnmarshalBinary(data []byte) error {
+	if len(data) != compactBoardSize {
+		return BoardFormatError{-1, fmt.Sprintf("expected %d bytes, got %d", compactBoardSize, len(data))}
+	}
+	var out board
+	var rooks [2]bool
+	for i := 0; i < 64; i++ {
+		nibble := data[i/2] >> uint(4*(i%2)) & 0xF
+		switch nibble {
+		case 1:
+			return BoardFormatError{i / 8, fmt.Sprintf("invalid square %d", i%8)}
+		case compactRook, compactActiveRook:
+			out[i/8][i%8] = ROOK | 0x10 | nibble&1
+			rooks[nibble&1] = true
+		default:
+			out[i/8][i%8] = nibble
+		}
+	}
+	for posY, r := range out {
+		for posX, piece := range r {
+			if piece&0xE == KING && rooks[piece&1] {
+				out[posY][posX] = piece | 0x10
+			}
+		}
+	}
+	*b = out
+	return nil
+}
+
+// compactBoard persists a board using the hex encoded compact binary
+// encoding.
+type compactBoard board
+
+func (b compactBoard) Value() (driver.Value, error) {
+	data, err := board(b).MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return hex.EncodeToString(data), nil
+}
+
+func (b *compactBoard) Scan(cell interface{}) error {
+	return (*board)(b).Scan(cell)
+}
+
 // BISHOP piece.
 const (
 	BISHOP uint8 = 2
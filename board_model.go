@@ -2,10 +2,10 @@ package models
 
 import (
 	"math"
+	"sync"
 	"time"
 
 	uuid "github.com/satori/go.uuid"
-	log "github.com/sirupsen/logrus"
 )
 
 // BoardStateMessage models.
@@ -41,14 +41,70 @@ func (err InvalidMove) Error() string {
 	return "Invalid move."
 }
 
-type cursorDelegate struct{}
+// CursorMessage models.
+//
+// Boards are from the active player view, as they are played with PUT, not
+// swapped to the next player view. Cursor is the zero UUID once every board
+// has been sent. With a lookahead above one Sequences replaces Boards, each a
+// next board followed by the boards after the moves played from it, all from
+// the mover view.
+type CursorMessage struct {
+	Cursor    uuid.UUID
+	Boards    []board
+	Sequences [][]board `json:",omitempty"`
+}
+
+// Sequences left to send for a game version and lookahead.
+type cursorEntry struct {
+	game      uuid.UUID
+	version   int
+	lookahead int
+	sequences [][]board
+	expires   time.Time
+}
+
+type cursorDelegate struct {
+	sync.Mutex
+	cursors map[uuid.UUID]cursorEntry
+}
+
+// Unread cursors are dropped after this long.
+const cursorTimeout = 5 * time.Minute
+
+// Boards per cursor slice.
+const cursorSlice = 450
+
+var cursors = cursorDelegate{cursors: make(map[uuid.UUID]cursorEntry)}
 
 // class CursorDelegate:
 //     def __init__(self):
 //         self.cursors = {}
 
 // Retrieve iterable for cursor.
-func (cursor cursorDelegate) getCursor() {
+//
+// Unknown, expired or stale cursors start over from the current state.
+func (cursor *cursorDelegate) getCursor(game boardModel, ID uuid.UUID, lookahead int) [][]board {
+	cursor.Lock()
+	defer cursor.Unlock()
+	now := time.Now()
+	for key, entry := range cursor.cursors {
+		if now.After(entry.expires) {
+			delete(cursor.cursors, key)
+		}
+	}
+	entry, ok := cursor.cursors[ID]
+	delete(cursor.cursors, ID)
+	if ok && entry.game == game.ID && entry.version == game.Version && entry.lookahead == lookahead {
+		return entry.sequences
+	}
+	if !game.active() {
+		return [][]board{}
+	}
+	sequences := [][]board{}
+	for _, root := range nextBoards(game.State) {
+		sequences = append(sequences, lookaheadSequences(root, lookahead)...)
+	}
+	return sequences
 }
 
 //     def get_cursor(self, board, cursor, lookahead, complete):
@@ -66,7 +122,24 @@ func (cursor cursorDelegate) getCursor() {
 //         return iter(()), iter(())
 
 // Retrieve REST cursor slice.
-func (cursor cursorDelegate) sliceCursorV1() {
+func (cursor *cursorDelegate) sliceCursorV1(game boardModel, ID uuid.UUID, lookahead int) CursorMessage {
+	sequences := cursor.getCursor(game, ID, lookahead)
+	var next uuid.UUID
+	if len(sequences) > cursorSlice {
+		next = newID()
+		cursor.Lock()
+		cursor.cursors[next] = cursorEntry{game.ID, game.Version, lookahead, sequences[cursorSlice:], time.Now().Add(cursorTimeout)}
+		cursor.Unlock()
+		sequences = sequences[:cursorSlice]
+	}
+	if lookahead > 1 {
+		return CursorMessage{Cursor: next, Boards: []board{}, Sequences: sequences}
+	}
+	boards := make([]board, len(sequences))
+	for i, sequence := range sequences {
+		boards[i] = sequence[0]
+	}
+	return CursorMessage{Cursor: next, Boards: boards}
 }
 
 //     def slice_cursor_v1(self, board, cursor, lookahead, complete):
@@ -133,140 +206,107 @@ func validationForPiece(piece uint8) func(board, int8, int8, [2]int8) bool {
 //         )[(piece & 0xE) // 2], posX, posY)
 
 // Get all possible moves for pawn.
-func movesForPawn(board board, piece uint8, posX int8, posY int8) <-chan [2]int8 {
-	out := make(chan [2]int8)
-	go func() {
-		if isOnBoard(posX, posY, [2]int8{0, -1}) && board[posY-1][posX] == 0 {
-			out <- [2]int8{0, -1}
-		}
-		if posY == 6 && board[5][posX] == 0 && board[4][posX] == 0 {
-			out <- [2]int8{0, -2}
-		}
-		if isOnBoard(posX, posY, [2]int8{-1, -1}) && inactivePiece(board[posY-1][posX-1]) {
-			out <- [2]int8{-1, -1}
-		}
-		if isOnBoard(posX, posY, [2]int8{1, -1}) && inactivePiece(board[posY-1][posX+1]) {
-			out <- [2]int8{1, -1}
-		}
-		if piece&0x10 != 0 {
-		}
-		close(out)
-	}()
+func movesForPawn(board board, piece uint8, posX int8, posY int8) [][2]int8 {
+	out := make([][2]int8, 0, 4)
+	if isOnBoard(posX, posY, [2]int8{0, -1}) && board[posY-1][posX] == 0 {
+		out = append(out, [2]int8{0, -1})
+	}
+	if posY == 6 && board[5][posX] == 0 && board[4][posX] == 0 {
+		out = append(out, [2]int8{0, -2})
+	}
+	if isOnBoard(posX, posY, [2]int8{-1, -1}) && inactivePiece(board[posY-1][posX-1]) {
+		out = append(out, [2]int8{-1, -1})
+	}
+	if isOnBoard(posX, posY, [2]int8{1, -1}) && inactivePiece(board[posY-1][posX+1]) {
+		out = append(out, [2]int8{1, -1})
+	}
+	if piece&0x10 != 0 {
+	}
 	return out
 }
 
 // Get castling.
-func movesForKing(board board, piece uint8, posX int8, posY int8) <-chan [2]int8 {
-	out := make(chan [2]int8)
-	go func() {
-		if piece&0x10 != 0 {
-			leftPiece := board[posY][0]
-			if leftPiece&0x10 != 0 && leftPiece&0x1 != 0 && leftPiece&0xE == ROOK {
-				out <- [2]int8{-2}
-				out <- [2]int8{-3}
-			}
-			rightPiece := board[posY][7]
-			if rightPiece&0x10 != 0 && rightPiece&0x1 != 0 && rightPiece&0xE == ROOK {
-				out <- [2]int8{2}
-			}
+func movesForKing(board board, piece uint8, posX int8, posY int8) [][2]int8 {
+	out := make([][2]int8, 0, 3)
+	if piece&0x10 != 0 {
+		leftPiece := board[posY][0]
+		if leftPiece&0x10 != 0 && leftPiece&0x1 != 0 && leftPiece&0xE == ROOK {
+			out = append(out, [2]int8{-2}, [2]int8{-3})
+		}
+		rightPiece := board[posY][7]
+		if rightPiece&0x10 != 0 && rightPiece&0x1 != 0 && rightPiece&0xE == ROOK {
+			out = append(out, [2]int8{2})
 		}
-		close(out)
-	}()
+	}
 	return out
 }
 
 // Get all possible moves for piece type.
-func movesForPiece(board board, piece uint8, posX int8, posY int8) <-chan [2]int8 {
-	out := make(chan [2]int8)
-	go func() {
-		switch piece & 0xE / 2 {
-		case 1:
-			for _, m := range bishopMoves {
-				out <- m
-			}
-		case 2:
-			for _, m := range kingMoves {
-				out <- m
-			}
-			for m := range movesForKing(board, piece, posX, posY) {
-				out <- m
-			}
-		case 3:
-			for _, m := range knightMoves {
-				out <- m
-			}
-		case 4:
-			for m := range movesForPawn(board, piece, posX, posY) {
-				out <- m
-			}
-		case 5:
-			for _, m := range queenMoves {
-				out <- m
-			}
-		case 6:
-			for _, m := range rookMoves {
-				out <- m
-			}
-		}
-		close(out)
-	}()
-	return out
+func movesForPiece(board board, piece uint8, posX int8, posY int8) [][2]int8 {
+	switch piece & 0xE / 2 {
+	case 1:
+		return bishopMoves[:]
+	case 2:
+		return append(kingMoves[:len(kingMoves):len(kingMoves)], movesForKing(board, piece, posX, posY)...)
+	case 3:
+		return knightMoves[:]
+	case 4:
+		return movesForPawn(board, piece, posX, posY)
+	case 5:
+		return queenMoves[:]
+	case 6:
+		return rookMoves[:]
+	}
+	return nil
 }
 
 // Get all valid moves for piece type.
-func validMovesForPiece(board board, piece uint8, posX int8, posY int8) <-chan [2]int8 {
-	out := make(chan [2]int8)
-	go func() {
-		filter := validationForPiece(piece)
-		for m := range movesForPiece(board, piece, posX, posY) {
-			if filter(board, posY, posX, m) {
-				out <- m
-			}
+func validMovesForPiece(board board, piece uint8, posX int8, posY int8) [][2]int8 {
+	filter := validationForPiece(piece)
+	out := make([][2]int8, 0, 8)
+	for _, m := range movesForPiece(board, piece, posX, posY) {
+		if isOnBoard(posX, posY, m) && filter(board, posX, posY, m) {
+			out = append(out, m)
 		}
-		close(out)
-	}()
+	}
 	return out
 }
 
-// Get all future board states.
-func lookaheadBoardsForPiece(b board, check bool, piece uint8, posX int8, posY int8) <-chan board {
-	out := make(chan board)
-
-	go func() {
-		mutateBoard := func(move [2]int8) {
-			newState := b
-			newState[posY][posX] = 0
-			if piece == 9 && posY == 1 {
-				for _, promote := range [4]uint8{BISHOP, KNIGHT, QUEEN, ROOK} {
-					newState[posY+move[1]][posX+move[0]] = promote | 1
-					out <- swap(newState)
-				}
-			} else {
-				newState[posY+move[1]][posX+move[0]] = piece & 0xF
-				out <- swap(newState)
+// Visit each future board state of a piece, from the active player view,
+// with its move and the piece a pawn promotes to.
+//
+// Castling is not generated, matching validateMutation.
+func visitBoardsForPiece(b board, piece uint8, posX int8, posY int8, visit func(move [2]int8, promote uint8, next board)) {
+	piece = piece & 0xF
+	for _, move := range validMovesForPiece(b, piece, posX, posY) {
+		newState := b
+		newState[posY][posX] = 0
+		if piece == 9 && posY == 1 {
+			for _, promote := range [4]uint8{BISHOP, KNIGHT, QUEEN, ROOK} {
+				newState[posY+move[1]][posX+move[0]] = promote | 1
+				visit(move, promote, newState)
 			}
+		} else {
+			newState[posY+move[1]][posX+move[0]] = piece
+			visit(move, 0, newState)
 		}
+	}
+}
 
-		validMovesForPiece := validMovesForPiece(b, piece, posX, posY)
-		if check {
-			for move := range validMovesForPiece {
-				if b[posY+move[1]][posX+move[0]]&0xF == KING {
-					mutateBoard(move)
-				}
-			}
-		} else {
-			for move := range validMovesForPiece {
-				mutateBoard(move)
-			}
+// Get all future board states.
+func lookaheadBoardsForPiece(b board, check bool, piece uint8, posX int8, posY int8) []board {
+	out := make([]board, 0, 8)
+	visitBoardsForPiece(b, piece, posX, posY, func(move [2]int8, promote uint8, next board) {
+		if !check || b[posY+move[1]][posX+move[0]]&0xF == KING {
+			out = append(out, swap(next))
 		}
-		close(out)
-	}()
+	})
 	return out
 }
 
 // Get possiblity of check in all future board states.
 func lookaheadCheckForPiece(board board, piece uint8, posX int8, posY int8) bool {
-	for move := range validMovesForPiece(board, piece, posX, posY) {
+	for _, move := range validMovesForPiece(board, piece&0xF, posX, posY) {
 		if board[posY+move[1]][posX+move[0]]&0xF == KING {
 			return true
 		}
@@ -276,7 +316,7 @@ func lookaheadCheckForPiece(board board, piece uint8, posX int8, posY int8) bool
 
 // Get possiblity of check in all future board states.
 func lookaheadCheck(board board) bool {
-	for piece := range activePieces(board) {
+	for _, piece := range activePieces(board) {
 		if lookaheadCheckForPiece(board, piece.piece, piece.posX, piece.posY) {
 			return true
 		}
@@ -284,6 +324,31 @@ func lookaheadCheck(board board) bool {
 	return false
 }
 
+// Get all future board states for the active player.
+//
+// While the opponent king can be taken only captures of it are kept.
+func lookaheadBoardsForBoard(b board, check bool) []board {
+	out := make([]board, 0, 32)
+	for _, piece := range activePieces(b) {
+		out = append(out, lookaheadBoardsForPiece(b, check, piece.piece, piece.posX, piece.posY)...)
+	}
+	return out
+}
+
+// Get all legal future board states, from the active player view as they
+// are played.
+//
+// A move is legal when no reply captures the active king.
+func nextBoards(b board) []board {
+	out := make([]board, 0, 32)
+	for _, next := range lookaheadBoardsForBoard(b, lookaheadCheck(b)) {
+		if !lookaheadCheck(next) {
+			out = append(out, swap(next))
+		}
+	}
+	return out
+}
+
 // Validate piece as active.
 func activePiece(piece uint8) bool {
 	return piece&1 != 0 && piece&0xE != 0
@@ -302,17 +367,15 @@ type Piece struct {
 }
 
 // Get all pieces for current player.
-func activePieces(board board) <-chan Piece {
-	out := make(chan Piece)
-	go func() {
-		for posY, r := range board {
-			for posX, piece := range r {
-				if activePiece(piece) {
-					out <- Piece{piece, int8(posX), int8(posY)}
-				}
+func activePieces(board board) []Piece {
+	out := make([]Piece, 0, 16)
+	for posY, r := range board {
+		for posX, piece := range r {
+			if activePiece(piece) {
+				out = append(out, Piece{piece, int8(posX), int8(posY)})
 			}
 		}
-	}()
+	}
 	return out
 }
 
@@ -340,18 +403,54 @@ type boardModel struct {
 	MovesSincePawn int
 	Player1        uuid.UUID
 	Player2        uuid.UUID
+	Version        int `gorm:"not null;default:0"`
+	EndReason      string
+	Player1Joined  int
+	Player2Joined  int
+	Player1URL     string
+	Player2URL     string
 }
 
 // Ensure active player king on board.
 func (board boardModel) active() bool {
-	return board.MovesSincePawn < 50 || board.hasKings()
+	return board.EndReason == "" && board.MovesSincePawn < 50 && board.hasKings()
+}
+
+// Game end reasons.
+const (
+	kingCaptured = "king captured"
+	fiftyMoves   = "fifty moves"
+	drawAgreed   = "draw agreed"
+	checkmate    = "checkmate"
+	stalemate    = "stalemate"
+)
+
+// Reason the game ends when the active player has no legal boards.
+func noMovesReason(state board) string {
+	if lookaheadCheck(swap(state)) {
+		return checkmate
+	}
+	return stalemate
+}
+
+// Reason the game ended, empty while active.
+func (board boardModel) endReason() string {
+	switch {
+	case board.EndReason != "":
+		return board.EndReason
+	case !board.hasKings():
+		return kingCaptured
+	case board.MovesSincePawn >= 50:
+		return fiftyMoves
+	}
+	return ""
 }
 
 // Ensure piece on board.
 func (board boardModel) contains(piece uint8) bool {
 	for _, r := range board.State {
 		for _, p := range r {
-			if p == piece&0xF {
+			if p&0xF == piece&0xF {
 				return true
 			}
 		}
@@ -373,50 +472,57 @@ type mutation struct {
 
 func (board *boardModel) validateMutation(mutation []mutation, state board) board {
 	if len(mutation) != 2 {
-		log.Panicln(InvalidMove{})
+		panic(InvalidMove{})
 	}
 	old := mutation[0]
 	new := mutation[1]
-	if mutation[0].prevPiece == 0 {
-	} else if mutation[1].prevPiece == 0 {
-		temp := old
-		old = new
-		new = temp
-	} else {
-		log.Panicln(InvalidMove{})
+	if old.nextPiece != 0 {
+		old, new = new, old
+	}
+	if old.nextPiece != 0 {
+		panic(InvalidMove{})
 	}
 	new.nextPiece = new.nextPiece & 0xF
-	if activePiece(new.prevPiece) {
-		log.Panicln(InvalidMove{})
+	if activePiece(new.prevPiece) || !activePiece(new.nextPiece) {
+		panic(InvalidMove{})
 	}
 	old.prevPiece = old.prevPiece & 0xF
 	if !activePiece(old.prevPiece) {
-		log.Panicln(InvalidMove{})
+		panic(InvalidMove{})
 	}
-	if old.prevPiece != new.nextPiece {
-		if old.prevPiece != 9 || new.posY != 0 {
-			log.Panicln(InvalidMove{})
+	if old.prevPiece == 9 && new.posY == 0 {
+		if !promotionPiece(new.nextPiece) {
+			panic(InvalidMove{})
 		}
+	} else if old.prevPiece != new.nextPiece {
+		panic(InvalidMove{})
 	}
 	move := [2]int8{new.posX - old.posX, new.posY - old.posY}
 	valid := false
-	for m := range validMovesForPiece(board.State, old.prevPiece, old.posX, old.posY) {
+	for _, m := range validMovesForPiece(board.State, old.prevPiece, old.posX, old.posY) {
 		if move[0] == m[0] && move[1] == m[1] {
 			valid = true
 		}
 	}
 	if !valid {
-		log.Panicln(InvalidMove{})
+		panic(InvalidMove{})
 	}
+	state[new.posY][new.posX] = new.nextPiece
 	if old.prevPiece == 9 {
 		board.MovesSincePawn = 0
-		if new.posY == 0 && new.nextPiece == 9 {
-			state[new.posY][new.posX] = QUEEN | 1
-		}
 	}
 	return swap(state)
 }
 
+// Pieces an active pawn may promote to.
+func promotionPiece(piece uint8) bool {
+	switch piece {
+	case BISHOP | 1, KNIGHT | 1, QUEEN | 1, ROOK | 1:
+		return true
+	}
+	return false
+}
+
 // Validate and return new board state.
 func (board *boardModel) update(state board) boardModel {
 	m := make([]mutation, 0)
@@ -427,10 +533,11 @@ func (board *boardModel) update(state board) boardModel {
 			}
 		}
 	}
-	var next boardModel
-	next.State = board.validateMutation(m, state)
-	next.MoveCount = board.MoveCount + 1
-	next.MovesSincePawn = board.MovesSincePawn + 1
+	next := *board
+	// Castling flags that can never be used again go with the move.
+	next.State = next.validateMutation(m, state).canonical()
+	next.MoveCount++
+	next.MovesSincePawn++
 	return next
 }
 
@@ -0,0 +1,112 @@
+package models
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Emoji indexed by piece with the active bit set for white pieces, the last
+// two entries are the dark and light empty squares.
+var emoji = [16]string{
+	"⌛", "‼",
+	"♝", "♗", "♚", "♔", "♞", "♘", "♟", "♙", "♛", "♕", "♜", "♖", "▪", "▫"}
+
+// ASCII letters indexed like emoji, white pieces are upper case.
+var ascii = [16]string{
+	"?", "?",
+	"b", "B", "k", "K", "n", "N", "p", "P", "q", "Q", "r", "R", ".", "."}
+
+// Board print styles.
+const (
+	emojiStyle   = "emoji"
+	asciiStyle   = "ascii"
+	unicodeStyle = "unicode"
+)
+
+// Index into the piece tables with the white bit set for white pieces.
+func pieceIndex(piece uint8, activeWhite bool) uint8 {
+	if activeWhite {
+		return piece & 0xF
+	}
+	return piece&0xE | (piece&1 ^ 1)
+}
+
+// Print the board with the active player at the bottom.
+func (b board) print(style string, activeWhite bool) string {
+	table := emoji
+	if style == asciiStyle {
+		table = ascii
+	}
+	rows := make([]string, 0, 9)
+	for posY, r := range b {
+		var row strings.Builder
+		if style == unicodeStyle {
+			rank := byte('8' - posY)
+			if !activeWhite {
+				rank = byte('1' + posY)
+			}
+			row.WriteByte(rank)
+			row.WriteByte(' ')
+		}
+		for posX, piece := range r {
+			switch {
+			case piece&0xE != 0:
+				row.WriteString(table[pieceIndex(piece, activeWhite)])
+			case style == unicodeStyle:
+				row.WriteString("·")
+			default:
+				row.WriteString(table[14+(posY+posX)%2])
+			}
+			if style == unicodeStyle && posX != 7 {
+				row.WriteByte(' ')
+			}
+		}
+		rows = append(rows, row.String())
+	}
+	if style == unicodeStyle {
+		files := "  a b c d e f g h"
+		if !activeWhite {
+			files = "  h g f e d c b a"
+		}
+		rows = append(rows, files)
+	}
+	return strings.Join(rows, "\n")
+}
+
+// Active player is white on even moves.
+func (board boardModel) activeWhite() bool {
+	return board.MoveCount%2 == 0
+}
+
+// Print the game for the requested style and side.
+//
+// style is one of emoji, ascii or unicode and side is white or black, by
+// default the board is printed for the active player.
+func (board boardModel) print(values url.Values) string {
+	style := values.Get("style")
+	switch style {
+	case "":
+		style = emojiStyle
+	case emojiStyle, asciiStyle, unicodeStyle:
+	default:
+		panic(InvalidMessage{"Unknown style " + style + "."})
+	}
+	activeWhite := board.activeWhite()
+	state := board.State
+	switch values.Get("side") {
+	case "":
+	case "white":
+		if !activeWhite {
+			state = swap(state)
+			activeWhite = true
+		}
+	case "black":
+		if activeWhite {
+			state = swap(state)
+			activeWhite = false
+		}
+	default:
+		panic(InvalidMessage{"Unknown side " + values.Get("side") + "."})
+	}
+	return state.print(style, activeWhite)
+}
@@ -0,0 +1,288 @@
+package models
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Polyglot key layout, 768 piece square keys then castling, en passant and
+// side to move.
+const (
+	polyglotCastling = 768
+	polyglotTurn     = 780
+	polyglotKeyCount = 781
+	// Hash of the initial position in the Polyglot book format description.
+	polyglotInitialHash = 0x463B96181691FC9C
+)
+
+// Random64 table of the Polyglot format, empty until LoadPolyglotKeys.
+var polyglotKeys []uint64
+
+// Polyglot piece kind by piece type, the white kind is one more.
+var polyglotPieces = [7]int{bishopType: 4, kingType: 10, knightType: 2, pawnType: 0, queenType: 8, rookType: 6}
+
+// Polyglot castling rights, the king and rook squares of each with white at
+// the bottom.
+var polyglotCastlingSquares = [4][2][2]int{
+	{{7, 4}, {7, 7}},
+	{{7, 4}, {7, 0}},
+	{{0, 4}, {0, 7}},
+	{{0, 4}, {0, 0}},
+}
+
+// Polyglot promotion pieces by move field.
+var polyglotPromotions = [5]uint8{0, KNIGHT, BISHOP, ROOK, QUEEN}
+
+var hexNumber = regexp.MustCompile(`0[xX]([0-9a-fA-F]{1,16})`)
+
+// LoadPolyglotKeys reads the Random64 table of the Polyglot format.
+//
+// The file holds the 781 keys as hexadecimal numbers with a 0x prefix in
+// table order, as in the format description or pg_key.c, anything between
+// them is ignored. The table is checked against the initial position hash.
+func LoadPolyglotKeys(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	matches := hexNumber.FindAllSubmatch(data, -1)
+	if len(matches) != polyglotKeyCount {
+		return fmt.Errorf("%s: %d keys, want %d", path, len(matches), polyglotKeyCount)
+	}
+	keys := make([]uint64, len(matches))
+	for i, match := range matches {
+		if keys[i], err = strconv.ParseUint(string(match[1]), 16, 64); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if hash := polyglotHashKeys(keys, initialBoard, true); hash != polyglotInitialHash {
+		return fmt.Errorf("%s: initial position hash %016x, want %016x", path, hash, uint64(polyglotInitialHash))
+	}
+	polyglotKeys = keys
+	return nil
+}
+
+// Polyglot hash of a board from the active player view.
+//
+// Boards do not record en passant captures, their keys are never used.
+func polyglotHash(state board, activeWhite bool) uint64 {
+	return polyglotHashKeys(polyglotKeys, state, activeWhite)
+}
+
+func polyglotHashKeys(keys []uint64, state board, activeWhite bool) uint64 {
+	var hash uint64
+	absolute := absoluteBoard(state, activeWhite)
+	for posY, r := range absolute {
+		for posX, piece := range r {
+			if piece&0xE == 0 {
+				continue
+			}
+			kind := polyglotPieces[piece&0xE/2] + int(piece&1)
+			hash ^= keys[64*kind+8*(7-posY)+posX]
+		}
+	}
+	for i, squares := range polyglotCastlingSquares {
+		king := absolute[squares[0][0]][squares[0][1]]
+		rook := absolute[squares[1][0]][squares[1][1]]
+		colour := uint8(1 - i/2)
+		if king&0x1F == KING|colour|0x10 && rook&0x1F == ROOK|colour|0x10 {
+			hash ^= keys[polyglotCastling+i]
+		}
+	}
+	if activeWhite {
+		hash ^= keys[polyglotTurn]
+	}
+	return hash
+}
+
+// Entry of a Polyglot book.
+type bookEntry struct {
+	key    uint64
+	move   uint16
+	weight uint16
+}
+
+// OpeningBook of weighted moves by Polyglot position hash.
+type OpeningBook struct {
+	entries []bookEntry
+}
+
+// ReadBook of Polyglot .bin entries sorted by key.
+func ReadBook(path string) (OpeningBook, error) {
+	var book OpeningBook
+	file, err := os.Open(path)
+	if err != nil {
+		return book, err
+	}
+	defer file.Close()
+	var raw [16]byte
+	for {
+		if _, err := io.ReadFull(file, raw[:]); err == io.EOF {
+			break
+		} else if err != nil {
+			return book, fmt.Errorf("%s: %w", path, err)
+		}
+		entry := bookEntry{
+			key:    binary.BigEndian.Uint64(raw[0:8]),
+			move:   binary.BigEndian.Uint16(raw[8:10]),
+			weight: binary.BigEndian.Uint16(raw[10:12]),
+		}
+		if n := len(book.entries); n > 0 && book.entries[n-1].key > entry.key {
+			return book, fmt.Errorf("%s: entries not sorted by key", path)
+		}
+		book.entries = append(book.entries, entry)
+	}
+	return book, nil
+}
+
+// Entries of a position.
+func (book OpeningBook) lookup(key uint64) []bookEntry {
+	start := sort.Search(len(book.entries), func(i int) bool { return book.entries[i].key >= key })
+	end := start
+	for end < len(book.entries) && book.entries[end].key == key {
+		end++
+	}
+	return book.entries[start:end]
+}
+
+// Board after a book move from the active player view, false when the move
+// is not a piece move of the active player.
+//
+// Castling is encoded as the king taking its own rook, it is never legal
+// here and decodes to false.
+func bookMove(state board, activeWhite bool, move uint16) (board, bool) {
+	toX, toY := int(move&7), 7-int(move>>3&7)
+	fromX, fromY := int(move>>6&7), 7-int(move>>9&7)
+	promote := int(move >> 12 & 7)
+	absolute := absoluteBoard(state, activeWhite)
+	colour := uint8(0)
+	if activeWhite {
+		colour = 1
+	}
+	piece := absolute[fromY][fromX] & 0xF
+	if piece&0xE == 0 || piece&1 != colour || promote >= len(polyglotPromotions) {
+		return state, false
+	}
+	if target := absolute[toY][toX]; target&0xE != 0 && target&1 == colour {
+		return state, false
+	}
+	if promote != 0 {
+		piece = polyglotPromotions[promote] | colour
+	}
+	absolute[fromY][fromX] = 0
+	absolute[toY][toX] = piece
+	return relativeBoard(absolute, activeWhite), true
+}
+
+// Opening books by name.
+var books = map[string]OpeningBook{}
+
+// RegisterBook for agents to play from by name.
+//
+// Books are registered at startup, after LoadPolyglotKeys.
+func RegisterBook(name string, book OpeningBook) error {
+	if polyglotKeys == nil {
+		return errors.New("Polyglot keys not loaded")
+	}
+	if _, ok := books[name]; ok {
+		return fmt.Errorf("book %s already registered", name)
+	}
+	books[name] = book
+	return nil
+}
+
+// LoadBooks registers every .bin book in a directory named after its file.
+func LoadBooks(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) != ".bin" {
+			continue
+		}
+		book, err := ReadBook(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		if err := RegisterBook(strings.TrimSuffix(entry.Name(), ".bin"), book); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Book layer in front of an agent.
+//
+// Plays a legal book move of the position at random by weight, the agent
+// plays once the position is out of book.
+type bookAgent struct {
+	book        OpeningBook
+	agent       baseAgent
+	state       board
+	activeWhite bool
+	rng         *rand.Rand
+}
+
+func (agent bookAgent) playRound(boards <-chan board) board {
+	var legal []board
+	for b := range boards {
+		legal = append(legal, b)
+	}
+	var moves []board
+	var weights []int
+	total := 0
+	for _, entry := range agent.book.lookup(polyglotHash(agent.state, agent.activeWhite)) {
+		next, ok := bookMove(agent.state, agent.activeWhite, entry.move)
+		if !ok || entry.weight == 0 {
+			continue
+		}
+		for _, b := range legal {
+			if b == next {
+				moves = append(moves, next)
+				weights = append(weights, int(entry.weight))
+				total += int(entry.weight)
+				break
+			}
+		}
+	}
+	if total > 0 {
+		pick := agent.rng.Intn(total)
+		for i, weight := range weights {
+			if pick < weight {
+				return moves[i]
+			}
+			pick -= weight
+		}
+	}
+	out := make(chan board, len(legal))
+	for _, b := range legal {
+		out <- b
+	}
+	close(out)
+	return agent.agent.playRound(out)
+}
+
+// Put the agent book in front of a delegate playing the position, from the
+// active player view.
+func (agent agentModel) bookLayer(delegate baseAgent, state board, activeWhite bool) baseAgent {
+	if agent.Book == "" {
+		return delegate
+	}
+	book, ok := books[agent.Book]
+	if !ok {
+		return delegate
+	}
+	// Seeded by position as well so each book position draws afresh.
+	rng := rand.New(rand.NewSource(agent.Seed ^ int64(polyglotHash(state, activeWhite))))
+	return bookAgent{book, delegate, state, activeWhite, rng}
+}
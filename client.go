@@ -0,0 +1,280 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// GameClient talks to a game on a game server.
+//
+// BaseURL is the game resource, /issue/{game}; relative references resolve
+// against it. Only GET requests are retried, moves and joins are sent once.
+type GameClient struct {
+	BaseURL   *url.URL
+	Transport http.RoundTripper
+	Timeout   time.Duration
+	Retries   int
+	Backoff   time.Duration
+}
+
+// DefaultGameClient settings used by agents.
+//
+// Set Transport to LocalTransport(NewHandler()) to play without a network.
+var DefaultGameClient = GameClient{
+	Transport: http.DefaultTransport,
+	Timeout:   10 * time.Second,
+	Retries:   2,
+	Backoff:   100 * time.Millisecond,
+}
+
+// GameServerError error.
+//
+// Status is zero when the server could not be reached.
+type GameServerError struct {
+	Status int
+	Reason string
+}
+
+func (err GameServerError) Error() string {
+	if err.Status == 0 {
+		return "Game server unreachable: " + err.Reason
+	}
+	return fmt.Sprintf("Game server error %d: %s", err.Status, err.Reason)
+}
+
+// NewGameClient client for the game at gameURL with the default settings.
+func NewGameClient(gameURL string) (*GameClient, error) {
+	base, err := url.Parse(gameURL)
+	if err != nil {
+		return nil, err
+	}
+	if !base.IsAbs() {
+		return nil, fmt.Errorf("game URL %q is not absolute", gameURL)
+	}
+	client := DefaultGameClient
+	client.BaseURL = base
+	return &client, nil
+}
+
+// Resolve a reference against the game, "states" is below the game.
+func (client GameClient) resolve(ref string) (string, error) {
+	path, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	base := *client.BaseURL
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+	if ref == "" {
+		return client.BaseURL.String(), nil
+	}
+	return base.ResolveReference(path).String(), nil
+}
+
+// Do sends a JSON request and decodes the JSON response into out.
+func (client GameClient) Do(ctx context.Context, method string, ref string, in interface{}, out interface{}) error {
+	target, err := client.resolve(ref)
+	if err != nil {
+		return err
+	}
+	var body []byte
+	if in != nil {
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	attempts := 1
+	if method == http.MethodGet {
+		attempts += client.Retries
+	}
+	backoff := client.Backoff
+	for attempt := 1; ; attempt++ {
+		err = client.attempt(ctx, method, target, body, out)
+		if err == nil || attempt >= attempts || !retryable(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// Send one request within the client timeout.
+func (client GameClient) attempt(ctx context.Context, method string, target string, body []byte, out interface{}) error {
+	if client.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, client.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	}
+	resp, err := (&http.Client{Transport: client.Transport}).Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return GameServerError{0, err.Error()}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var message ErrorMessage
+		if json.NewDecoder(resp.Body).Decode(&message) != nil || message.Error == "" {
+			message.Error = http.StatusText(resp.StatusCode)
+		}
+		return GameServerError{resp.StatusCode, message.Error}
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return GameServerError{resp.StatusCode, err.Error()}
+	}
+	return nil
+}
+
+// Unreachable servers and server errors may succeed on retry.
+func retryable(err error) bool {
+	server, ok := err.(GameServerError)
+	return ok && (server.Status == 0 || server.Status >= 500)
+}
+
+// State of the game.
+func (client GameClient) State(ctx context.Context) (BoardStateMessage, error) {
+	var message BoardStateMessage
+	err := client.Do(ctx, http.MethodGet, "", nil, &message)
+	return message, err
+}
+
+// Board of the game with white at the bottom.
+func (client GameClient) Board(ctx context.Context) (AbsoluteStateMessage, error) {
+	var message AbsoluteStateMessage
+	err := client.Do(ctx, http.MethodGet, "board", nil, &message)
+	return message, err
+}
+
+// States slice of the legal next boards, start with the zero cursor.
+func (client GameClient) States(ctx context.Context, cursor uuid.UUID) (CursorMessage, error) {
+	params := url.Values{"lookahead": {"1"}}
+	if validID(cursor) {
+		params.Set("cursor", cursor.String())
+	}
+	var message CursorMessage
+	err := client.Do(ctx, http.MethodGet, "states?"+params.Encode(), nil, &message)
+	return message, err
+}
+
+// Sequences of lookahead moves from each next board, a slice at a time.
+func (client GameClient) Sequences(ctx context.Context, cursor uuid.UUID, lookahead int) (CursorMessage, error) {
+	params := url.Values{"lookahead": {strconv.Itoa(lookahead)}}
+	if validID(cursor) {
+		params.Set("cursor", cursor.String())
+	}
+	var message CursorMessage
+	err := client.Do(ctx, http.MethodGet, "states?"+params.Encode(), nil, &message)
+	return message, err
+}
+
+// Join the game.
+func (client GameClient) Join(ctx context.Context, join GameJoinMessage) (BoardStateMessage, error) {
+	var message BoardStateMessage
+	err := client.Do(ctx, http.MethodPost, "", join, &message)
+	return message, err
+}
+
+// Play the next board state.
+func (client GameClient) Play(ctx context.Context, state board) (BoardStateMessage, error) {
+	var message BoardStateMessage
+	err := client.Do(ctx, http.MethodPut, "", PlayMessage{state}, &message)
+	return message, err
+}
+
+// LocalTransport serves requests with handler in process, without a network.
+func LocalTransport(handler http.Handler) http.RoundTripper {
+	return localTransport{handler}
+}
+
+type localTransport struct{ handler http.Handler }
+
+func (transport localTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	r.RequestURI = req.URL.RequestURI()
+	r.RemoteAddr = "127.0.0.1:0"
+	if r.Body == nil {
+		r.Body = http.NoBody
+	}
+	w := &localResponse{header: make(http.Header)}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		transport.handler.ServeHTTP(w, r)
+	}()
+	select {
+	case <-done:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        w.header,
+		Body:          io.NopCloser(bytes.NewReader(w.body.Bytes())),
+		ContentLength: int64(w.body.Len()),
+		Request:       req,
+	}, nil
+}
+
+// Buffered response of an in process request.
+type localResponse struct {
+	sync.Mutex
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *localResponse) Header() http.Header {
+	return w.header
+}
+
+func (w *localResponse) WriteHeader(status int) {
+	w.Lock()
+	defer w.Unlock()
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *localResponse) Write(data []byte) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(data)
+}
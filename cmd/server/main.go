@@ -0,0 +1,44 @@
+// Command server serves the neuralknight game and agent REST API.
+package main
+
+import (
+	"flag"
+	"net/http"
+	"os"
+
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	models "github.com/neuralknight/backend-models"
+	log "github.com/sirupsen/logrus"
+)
+
+func defaultAddr() string {
+	if port := os.Getenv("PORT"); port != "" {
+		return ":" + port
+	}
+	return ":8080"
+}
+
+func main() {
+	addr := flag.String("addr", defaultAddr(), "address to listen on")
+	profiles := flag.String("profiles", "", "directory of evaluation profiles to register as delegates")
+	bookKeys := flag.String("book-keys", "", "Polyglot Random64 key table, needed by -books")
+	books := flag.String("books", "", "directory of Polyglot opening books agents may play from")
+	flag.Parse()
+	if *profiles != "" {
+		if err := models.LoadProfiles(*profiles); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if *bookKeys != "" {
+		if err := models.LoadPolyglotKeys(*bookKeys); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if *books != "" {
+		if err := models.LoadBooks(*books); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	log.Infoln("listening on", *addr)
+	log.Fatalln(http.ListenAndServe(*addr, models.NewHandler()))
+}
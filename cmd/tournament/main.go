@@ -0,0 +1,206 @@
+// Command tournament plays round-robin or gauntlet events between the
+// registered agents in process and reports a crosstable with Elo estimates.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	models "github.com/neuralknight/backend-models"
+	log "github.com/sirupsen/logrus"
+)
+
+// Pair of players for one game.
+type pairing struct {
+	white, black int
+}
+
+// Game to play.
+type job struct {
+	pairing
+	opening []models.AbsoluteMoveMessage
+	seed    int64
+}
+
+// Score of a game for white.
+func whiteScore(result models.MatchResult) float64 {
+	switch result.Winner {
+	case "white":
+		return 1
+	case "black":
+		return 0
+	}
+	return 0.5
+}
+
+// Pairings of each event mode, each played once per colour.
+func pairings(mode string, players int) ([]pairing, error) {
+	out := make([]pairing, 0)
+	switch mode {
+	case "roundrobin":
+		for i := 0; i < players; i++ {
+			for j := i + 1; j < players; j++ {
+				out = append(out, pairing{i, j})
+			}
+		}
+	case "gauntlet":
+		for j := 1; j < players; j++ {
+			out = append(out, pairing{0, j})
+		}
+	default:
+		return nil, fmt.Errorf("unknown mode %q", mode)
+	}
+	return out, nil
+}
+
+func main() {
+	profiles := flag.String("profiles", "", "directory of evaluation profiles to register as agents")
+	mode := flag.String("mode", "roundrobin", "roundrobin or gauntlet, where the first agent plays every other")
+	names := flag.String("agents", "", "comma separated agents, may repeat, every registered agent by default")
+	rounds := flag.Int("rounds", 10, "openings per pairing, each played with both colours")
+	openingMoves := flag.Int("opening", 4, "random opening moves")
+	moveLimit := flag.Int("moves", 300, "moves before a game is drawn, zero for no limit")
+	parallel := flag.Int("parallel", runtime.NumCPU(), "games played at once")
+	seed := flag.Int64("seed", time.Now().UnixNano(), "opening and agent random seed")
+	deterministic := flag.Bool("deterministic", false, "run mcts agents on one worker so games repeat with the seed")
+	sprt := flag.Bool("sprt", false, "stop early once an SPRT between two agents concludes")
+	elo0 := flag.Float64("elo0", 0, "SPRT null hypothesis Elo difference")
+	elo1 := flag.Float64("elo1", 10, "SPRT alternative hypothesis Elo difference")
+	alpha := flag.Float64("alpha", 0.05, "SPRT false positive rate")
+	beta := flag.Float64("beta", 0.05, "SPRT false negative rate")
+	bookKeys := flag.String("book-keys", "", "Polyglot Random64 key table, needed by -book")
+	bookPath := flag.String("book", "", "Polyglot opening book every agent plays from")
+	flag.Parse()
+
+	if *profiles != "" {
+		if err := models.LoadProfiles(*profiles); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if *bookPath != "" {
+		if err := models.LoadPolyglotKeys(*bookKeys); err != nil {
+			log.Fatalln(err)
+		}
+		book, err := models.ReadBook(*bookPath)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if err := models.RegisterBook(*bookPath, book); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if *names == "" {
+		*names = strings.Join(models.AgentNames(), ",")
+	}
+	players := strings.Split(*names, ",")
+	pairs, err := pairings(*mode, len(players))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if len(pairs) == 0 {
+		log.Fatalln("need at least two agents")
+	}
+	var test *sprtTest
+	if *sprt {
+		if len(players) != 2 {
+			log.Fatalln("SPRT needs exactly two agents")
+		}
+		test = newSPRT(*elo0, *elo1, *alpha, *beta)
+	}
+	log.Infoln("seed", *seed)
+
+	jobs := make(chan job)
+	done := make(chan struct{})
+	go func() {
+		defer close(jobs)
+		rng := rand.New(rand.NewSource(*seed))
+		for round := 0; round < *rounds; round++ {
+			for _, pair := range pairs {
+				opening := models.RandomOpening(*openingMoves, rng)
+				for _, game := range []pairing{pair, {pair.black, pair.white}} {
+					select {
+					case jobs <- job{game, opening, rng.Int63()}:
+					case <-done:
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	type played struct {
+		job
+		result models.MatchResult
+		err    error
+	}
+	results := make(chan played)
+	var workers sync.WaitGroup
+	for i := 0; i < *parallel; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for game := range jobs {
+				result, err := models.Match{
+					White:         players[game.white],
+					Black:         players[game.black],
+					Opening:       game.opening,
+					MoveLimit:     *moveLimit,
+					Seed:          game.seed,
+					Deterministic: *deterministic,
+					WhiteBook:     *bookPath,
+					BlackBook:     *bookPath,
+				}.Play()
+				results <- played{game, result, err}
+			}
+		}()
+	}
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
+
+	table := newCrosstable(players)
+	stopped := false
+	var failed error
+	for game := range results {
+		if game.err != nil {
+			// Stop scheduling and let the running games finish.
+			if failed == nil {
+				failed = game.err
+				if !stopped {
+					stopped = true
+					close(done)
+				}
+			}
+			continue
+		}
+		score := whiteScore(game.result)
+		table.add(game.white, game.black, score)
+		log.Debugln(game.result.White, game.result.Black, game.result.Winner, game.result.Reason, len(game.result.Moves))
+		if test == nil || stopped {
+			continue
+		}
+		if game.white == 1 {
+			score = 1 - score
+		}
+		test.add(score)
+		if test.decided() != "" {
+			stopped = true
+			close(done)
+		}
+	}
+
+	if failed != nil {
+		log.Fatalln(failed)
+	}
+	table.print(os.Stdout)
+	if test != nil {
+		test.print(os.Stdout, players[0], players[1])
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"text/tabwriter"
+)
+
+// Wins, draws and losses from one side.
+type record struct {
+	wins, draws, losses int
+}
+
+func (r *record) add(score float64) {
+	switch score {
+	case 1:
+		r.wins++
+	case 0:
+		r.losses++
+	default:
+		r.draws++
+	}
+}
+
+func (r record) games() int {
+	return r.wins + r.draws + r.losses
+}
+
+// Mean score per game.
+func (r record) score() float64 {
+	return (float64(r.wins) + float64(r.draws)/2) / float64(r.games())
+}
+
+// Elo difference for a mean score.
+func eloForScore(score float64) float64 {
+	return 400 * math.Log10(score/(1-score))
+}
+
+// Mean score for an Elo difference.
+func scoreForElo(elo float64) float64 {
+	return 1 / (1 + math.Pow(10, -elo/400))
+}
+
+// Variance of a single game score.
+func (r record) variance() float64 {
+	mean := r.score()
+	n := float64(r.games())
+	return (float64(r.wins)*math.Pow(1-mean, 2) +
+		float64(r.draws)*math.Pow(0.5-mean, 2) +
+		float64(r.losses)*math.Pow(mean, 2)) / n
+}
+
+// Elo estimate with a 95% confidence margin.
+//
+// Perfect and zero scores have no finite estimate.
+func (r record) elo() (float64, float64) {
+	mean := r.score()
+	if mean <= 0 || mean >= 1 {
+		return math.Copysign(math.Inf(1), mean-0.5), math.NaN()
+	}
+	margin := 1.959964 * math.Sqrt(r.variance()/float64(r.games()))
+	low := eloForScore(math.Max(mean-margin, 1e-9))
+	high := eloForScore(math.Min(mean+margin, 1-1e-9))
+	return eloForScore(mean), (high - low) / 2
+}
+
+// Results of every player against every other.
+type crosstable struct {
+	players []string
+	results [][]record
+}
+
+func newCrosstable(players []string) *crosstable {
+	results := make([][]record, len(players))
+	for i := range results {
+		results[i] = make([]record, len(players))
+	}
+	return &crosstable{players, results}
+}
+
+// Record a game with the score for white.
+func (table *crosstable) add(white, black int, score float64) {
+	table.results[white][black].add(score)
+	table.results[black][white].add(1 - score)
+}
+
+// Results of a player against the field.
+func (table *crosstable) total(player int) record {
+	var total record
+	for _, r := range table.results[player] {
+		total.wins += r.wins
+		total.draws += r.draws
+		total.losses += r.losses
+	}
+	return total
+}
+
+func (table *crosstable) print(w io.Writer) {
+	out := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	header := []string{"", "agent"}
+	for i := range table.players {
+		header = append(header, fmt.Sprint(i+1))
+	}
+	header = append(header, "games", "score", "elo", "")
+	fmt.Fprintln(out, strings.Join(header, "\t")+"\t")
+	for i, name := range table.players {
+		row := []string{fmt.Sprint(i + 1), name}
+		for j, r := range table.results[i] {
+			if i == j || r.games() == 0 {
+				row = append(row, "-")
+				continue
+			}
+			row = append(row, fmt.Sprintf("%d-%d-%d", r.wins, r.draws, r.losses))
+		}
+		total := table.total(i)
+		if total.games() == 0 {
+			row = append(row, "0", "-", "-", "")
+		} else {
+			elo, margin := total.elo()
+			bar := ""
+			if !math.IsNaN(margin) {
+				bar = fmt.Sprintf("±%.0f", margin)
+			}
+			row = append(row,
+				fmt.Sprint(total.games()),
+				fmt.Sprintf("%.1f%%", 100*total.score()),
+				fmt.Sprintf("%+.0f", elo),
+				bar)
+		}
+		fmt.Fprintln(out, strings.Join(row, "\t")+"\t")
+	}
+	out.Flush()
+}
+
+// Sequential probability ratio test between two Elo hypotheses.
+//
+// Uses the normal approximation of the log likelihood ratio from the mean
+// and variance of the game scores.
+type sprtTest struct {
+	elo0, elo1   float64
+	lower, upper float64
+	record
+}
+
+func newSPRT(elo0, elo1, alpha, beta float64) *sprtTest {
+	return &sprtTest{
+		elo0:  elo0,
+		elo1:  elo1,
+		lower: math.Log(beta / (1 - alpha)),
+		upper: math.Log((1 - beta) / alpha),
+	}
+}
+
+// Log likelihood ratio of the results so far.
+func (test *sprtTest) llr() float64 {
+	if test.games() == 0 {
+		return 0
+	}
+	variance := test.variance()
+	if variance == 0 {
+		return 0
+	}
+	s0, s1 := scoreForElo(test.elo0), scoreForElo(test.elo1)
+	n := float64(test.games())
+	return n * (s1 - s0) * (2*test.score() - s0 - s1) / (2 * variance)
+}
+
+// H0 or H1 once a bound is crossed, empty until then.
+func (test *sprtTest) decided() string {
+	switch llr := test.llr(); {
+	case llr <= test.lower:
+		return "H0"
+	case llr >= test.upper:
+		return "H1"
+	}
+	return ""
+}
+
+func (test *sprtTest) print(w io.Writer, first, second string) {
+	fmt.Fprintf(w, "SPRT %s vs %s elo0=%g elo1=%g: LLR %.2f [%.2f, %.2f]", first, second, test.elo0, test.elo1, test.llr(), test.lower, test.upper)
+	switch test.decided() {
+	case "H0":
+		fmt.Fprintln(w, ", H0 accepted")
+	case "H1":
+		fmt.Fprintln(w, ", H1 accepted")
+	default:
+		fmt.Fprintln(w, ", inconclusive")
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func near(a, b, tolerance float64) bool {
+	return math.Abs(a-b) <= tolerance
+}
+
+func TestEloForScore(t *testing.T) {
+	for _, test := range []struct {
+		score, elo float64
+	}{
+		{0.5, 0},
+		{0.75, 190.8485},
+		{0.25, -190.8485},
+		{0.9, 381.6970},
+	} {
+		if elo := eloForScore(test.score); !near(elo, test.elo, 1e-3) {
+			t.Error("unexpected elo", test.score, elo, test.elo)
+		}
+		if score := scoreForElo(test.elo); !near(score, test.score, 1e-6) {
+			t.Error("unexpected score", test.elo, score, test.score)
+		}
+	}
+}
+
+func TestRecordElo(t *testing.T) {
+	for _, test := range []struct {
+		record      record
+		elo, margin float64
+	}{
+		{record{60, 20, 20}, 147.1907, 66.0134},
+		{record{10, 80, 10}, 0, 30.5319},
+		{record{30, 40, 30}, 0, 53.1580},
+		{record{3, 0, 0}, math.Inf(1), math.NaN()},
+		{record{0, 0, 3}, math.Inf(-1), math.NaN()},
+	} {
+		elo, margin := test.record.elo()
+		if math.IsInf(test.elo, 0) {
+			if elo != test.elo || !math.IsNaN(margin) {
+				t.Error("expected an infinite estimate", test.record, elo, margin)
+			}
+			continue
+		}
+		if !near(elo, test.elo, 1e-3) || !near(margin, test.margin, 1e-3) {
+			t.Error("unexpected estimate", test.record, elo, margin, test.elo, test.margin)
+		}
+	}
+}
+
+func TestSPRT(t *testing.T) {
+	for _, test := range []struct {
+		record  record
+		llr     float64
+		decided string
+	}{
+		{record{}, 0, ""},
+		{record{0, 10, 0}, 0, ""},
+		{record{60, 20, 20}, 1.7337, ""},
+		{record{100, 100, 100}, -0.1863, ""},
+		{record{600, 200, 200}, 17.3371, "H1"},
+		{record{200, 200, 600}, -18.6308, "H0"},
+	} {
+		sprt := newSPRT(0, 10, 0.05, 0.05)
+		sprt.record = test.record
+		if llr := sprt.llr(); !near(llr, test.llr, 1e-3) {
+			t.Error("unexpected LLR", test.record, llr, test.llr)
+		}
+		if decided := sprt.decided(); decided != test.decided {
+			t.Error("unexpected decision", test.record, decided, test.decided)
+		}
+	}
+	if sprt := newSPRT(0, 10, 0.05, 0.05); !near(sprt.lower, -2.9444, 1e-3) || !near(sprt.upper, 2.9444, 1e-3) {
+		t.Error("unexpected bounds", sprt.lower, sprt.upper)
+	}
+}
@@ -0,0 +1,81 @@
+// Command tune fits the weights of an evaluation profile to game results
+// with the Texel method and writes out the tuned profile.
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	models "github.com/neuralknight/backend-models"
+	log "github.com/sirupsen/logrus"
+)
+
+// Profile from a file, or a built in profile by name.
+func loadProfile(name string) (models.EvaluationProfile, error) {
+	switch filepath.Ext(name) {
+	case ".json", ".yaml", ".yml":
+		return models.ReadProfile(name)
+	}
+	return models.BuiltinProfile(name)
+}
+
+func main() {
+	profileName := flag.String("profile", "balance", "profile file, or the positive or balance built in profile")
+	epd := flag.String("epd", "", "comma separated EPD files of positions labelled with results")
+	history := flag.Bool("history", false, "tune on the positions of finished games in the database")
+	skip := flag.Int("skip", 8, "opening moves of each history game left out")
+	passes := flag.Int("passes", 0, "passes over every parameter, zero until no progress")
+	step := flag.Int("step", 1, "change tried on each parameter")
+	out := flag.String("out", "", "JSON or YAML file to write the tuned profile to")
+	name := flag.String("name", "", "name of the tuned profile, the out file name by default")
+	flag.Parse()
+
+	if *out == "" {
+		log.Fatalln("-out is required")
+	}
+	profile, err := loadProfile(*profileName)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	var positions []models.TuningPosition
+	if *epd != "" {
+		for _, path := range strings.Split(*epd, ",") {
+			file, err := os.Open(path)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			read, err := models.ReadEPD(file)
+			file.Close()
+			if err != nil {
+				log.Fatalln(path, err)
+			}
+			positions = append(positions, read...)
+		}
+	}
+	if *history {
+		positions = append(positions, models.HistoryPositions(*skip)...)
+	}
+	log.Infoln("positions", len(positions))
+
+	tuned, result, err := models.TuneProfile(profile, positions, models.TuningOptions{
+		Passes: *passes,
+		Step:   *step,
+		Progress: func(pass int, err float64) {
+			log.Infof("pass %d error %.6f", pass, err)
+		},
+	})
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Infof("K %.3g error %.6f -> %.6f in %d passes", result.K, result.InitialError, result.FinalError, result.Passes)
+	tuned.Name = *name
+	if tuned.Name == "" {
+		tuned.Name = strings.TrimSuffix(filepath.Base(*out), filepath.Ext(*out))
+	}
+	if err := models.WriteProfile(*out, tuned); err != nil {
+		log.Fatalln(err)
+	}
+}
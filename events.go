@@ -0,0 +1,216 @@
+package models
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// Game event types.
+const (
+	stateEvent = "state"
+	joinEvent  = "join"
+	moveEvent  = "move"
+	drawEvent  = "draw"
+	endEvent   = "end"
+
+	// Global feed of new games.
+	createEvent = "create"
+)
+
+// GameEventMessage events.
+//
+// ID is the game version after the event, draw offers are not saved and
+// carry the current version. Join events only name the Player.
+type GameEventMessage struct {
+	ID        int
+	Game      uuid.UUID
+	Type      string
+	State     board
+	MoveCount int
+	Player    uuid.UUID
+	Reason    string
+}
+
+// Move history.
+type moveModel struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	GameID    uuid.UUID `gorm:"index"`
+	Version   int
+	MoveCount int
+	Player    uuid.UUID
+	State     board `gorm:"type:varchar;size:136;not null"`
+}
+
+// Player to move.
+func (board boardModel) activePlayer() uuid.UUID {
+	if board.activeWhite() {
+		return board.Player1
+	}
+	return board.Player2
+}
+
+// Save the move that produced the current state.
+func (board boardModel) recordMove(db *gorm.DB, player uuid.UUID) {
+	move := moveModel{
+		GameID:    board.ID,
+		Version:   board.Version,
+		MoveCount: board.MoveCount,
+		Player:    player,
+		State:     board.State,
+	}
+	if err := db.Create(&move).Error; err != nil {
+		log.Panicln(err)
+	}
+}
+
+// Moves saved after version.
+func (board boardModel) movesSince(version int) []moveModel {
+	db := openDB()
+	defer closeDB(db)
+	var moves []moveModel
+	err := db.Where("game_id = ? AND version > ?", board.ID, version).Order("version").Find(&moves).Error
+	if err != nil {
+		log.Panicln(err)
+	}
+	return moves
+}
+
+func (board boardModel) event(kind string, player uuid.UUID) GameEventMessage {
+	if kind == joinEvent {
+		return joinedEvent(board.ID, board.Version, player)
+	}
+	event := GameEventMessage{
+		ID:        board.Version,
+		Game:      board.ID,
+		Type:      kind,
+		State:     board.State,
+		MoveCount: board.MoveCount,
+		Player:    player,
+	}
+	if kind == endEvent {
+		event.Reason = board.endReason()
+	}
+	return event
+}
+
+func joinedEvent(game uuid.UUID, version int, player uuid.UUID) GameEventMessage {
+	return GameEventMessage{ID: version, Game: game, Type: joinEvent, Player: player}
+}
+
+func (move moveModel) event() GameEventMessage {
+	return GameEventMessage{
+		ID:        move.Version,
+		Game:      move.GameID,
+		Type:      moveEvent,
+		State:     move.State,
+		MoveCount: move.MoveCount,
+		Player:    move.Player,
+	}
+}
+
+// Events after version, replayed from the move history and followed by the
+// current state.
+func (board boardModel) replay(version int) []GameEventMessage {
+	out := make([]GameEventMessage, 0)
+	joins := []GameEventMessage{
+		joinedEvent(board.ID, board.Player1Joined, board.Player1),
+		joinedEvent(board.ID, board.Player2Joined, board.Player2),
+	}
+	for _, move := range board.movesSince(version) {
+		for len(joins) != 0 && joins[0].ID < move.Version {
+			if validID(joins[0].Player) && joins[0].ID > version {
+				out = append(out, joins[0])
+			}
+			joins = joins[1:]
+		}
+		out = append(out, move.event())
+	}
+	for _, join := range joins {
+		if validID(join.Player) && join.ID > version {
+			out = append(out, join)
+		}
+	}
+	if !board.active() && board.Version > version {
+		out = append(out, board.event(endEvent, uuid.UUID{}))
+	}
+	return append(out, board.event(stateEvent, board.activePlayer()))
+}
+
+// Subscribers are dropped when they fall this far behind, clients replay
+// what they missed on reconnect.
+const eventBuffer = 64
+
+type eventHub struct {
+	sync.Mutex
+	subscribers map[uuid.UUID]map[chan GameEventMessage]bool
+	drawOffers  map[uuid.UUID]uuid.UUID
+}
+
+var events = eventHub{
+	subscribers: make(map[uuid.UUID]map[chan GameEventMessage]bool),
+	drawOffers:  make(map[uuid.UUID]uuid.UUID),
+}
+
+// Receive events for a game until unsubscribed.
+func (hub *eventHub) subscribe(game uuid.UUID) chan GameEventMessage {
+	hub.Lock()
+	defer hub.Unlock()
+	out := make(chan GameEventMessage, eventBuffer)
+	if hub.subscribers[game] == nil {
+		hub.subscribers[game] = make(map[chan GameEventMessage]bool)
+	}
+	hub.subscribers[game][out] = true
+	return out
+}
+
+func (hub *eventHub) unsubscribe(game uuid.UUID, out chan GameEventMessage) {
+	hub.Lock()
+	defer hub.Unlock()
+	if hub.subscribers[game][out] {
+		delete(hub.subscribers[game], out)
+		close(out)
+	}
+	if len(hub.subscribers[game]) == 0 {
+		delete(hub.subscribers, game)
+	}
+}
+
+func (hub *eventHub) publish(event GameEventMessage) {
+	hub.publishTo(event.Game, event)
+}
+
+// Publish to the subscribers of topic, the zero ID is the global feed.
+func (hub *eventHub) publishTo(topic uuid.UUID, event GameEventMessage) {
+	hub.Lock()
+	defer hub.Unlock()
+	if event.Type != drawEvent {
+		delete(hub.drawOffers, topic)
+	}
+	for out := range hub.subscribers[topic] {
+		select {
+		case out <- event:
+		default:
+			delete(hub.subscribers[topic], out)
+			close(out)
+		}
+	}
+}
+
+// Record a draw offer, returns true when it accepts an offer from the
+// other player.
+func (hub *eventHub) offerDraw(game uuid.UUID, player uuid.UUID) bool {
+	hub.Lock()
+	defer hub.Unlock()
+	offer, ok := hub.drawOffers[game]
+	if ok && offer != player {
+		delete(hub.drawOffers, game)
+		return true
+	}
+	hub.drawOffers[game] = player
+	return false
+}
@@ -0,0 +1,343 @@
+package models
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+// TestBoard is exported for tests.
+type TestBoard = board
+
+// TestCompactBoard is exported for tests.
+type TestCompactBoard = compactBoard
+
+// SetCompactColumns switches the board column encoding for tests.
+func SetCompactColumns(compact bool) {
+	compactColumns = compact
+}
+
+// StoredState is the raw state column of a game for tests.
+func StoredState(ID uuid.UUID) []byte {
+	db := openDB()
+	defer closeDB(db)
+	var raw []byte
+	if err := db.Table("board_models").Where("id = ?", ID).Select("state").Row().Scan(&raw); err != nil {
+		panic(err)
+	}
+	return raw
+}
+
+// InitialBoard is exported for tests.
+var InitialBoard = initialBoard
+
+// Canonical is exported for tests.
+func (b board) Canonical() board {
+	return b.canonical()
+}
+
+// Print is exported for tests.
+func (b board) Print(style string, activeWhite bool) string {
+	return b.print(style, activeWhite)
+}
+
+// ValidUpdate reports whether the active player may move from state to next
+// for tests.
+func ValidUpdate(state board, next board) (valid bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			if _, ok := err.(InvalidMove); !ok {
+				panic(err)
+			}
+			valid = false
+		}
+	}()
+	b := boardModel{State: state}
+	b.update(next)
+	return true
+}
+
+func nextBoardsChannel(state board) <-chan board {
+	boards := make(chan board, 256)
+	for _, b := range nextBoards(state) {
+		boards <- b
+	}
+	close(boards)
+	return boards
+}
+
+// SearchMove is the search agent choice at depth for tests.
+func SearchMove(state board, depth int) board {
+	return newSearchAgent(positiveWeightAgent(), depth).playRound(nextBoardsChannel(state))
+}
+
+// AgentSearchMove is the search choice at depth of an agent with its own
+// table for tests.
+func AgentSearchMove(ID uuid.UUID, state board, depth int) board {
+	agent := newSearchAgent(positiveWeightAgent(), depth).configure(agentModel{ID: ID, Lookahead: depth})
+	return agent.playRound(nextBoardsChannel(state))
+}
+
+// SearchDepth of the search agent configured with a lookahead and a move
+// time for tests.
+func SearchDepth(lookahead int, moveTime int) int {
+	return agents["search-agent"].(configurableAgent).configure(agentModel{Lookahead: lookahead, MoveTime: moveTime}).(searchAgent).depth
+}
+
+// ReserveTable of an agent for tests.
+func ReserveTable(ID uuid.UUID, size int) {
+	tableForAgent(ID, size)
+}
+
+// TimedSearchMove is the search agent choice within moveTime milliseconds
+// for tests.
+func TimedSearchMove(state board, moveTime int) board {
+	agent := newSearchAgent(positiveWeightAgent(), defaultSearchDepth).configure(agentModel{MoveTime: moveTime})
+	return agent.playRound(nextBoardsChannel(state))
+}
+
+// MCTSMove is the mcts agent choice after iterations for tests, guided by
+// the evaluation or with random playouts.
+func MCTSMove(state board, iterations int, guided bool) board {
+	agent := newMCTSAgent(positiveWeightAgent(), guided).configure(agentModel{Iterations: iterations, Deterministic: true})
+	return agent.playRound(nextBoardsChannel(state))
+}
+
+// TimedMCTSMove is the mcts agent choice within moveTime milliseconds for
+// tests.
+func TimedMCTSMove(state board, moveTime int) board {
+	agent := newMCTSAgent(positiveWeightAgent(), true).configure(agentModel{MoveTime: moveTime})
+	return agent.playRound(nextBoardsChannel(state))
+}
+
+// WeightMove is the weight agent choice with the balance or positive
+// weights for tests.
+func WeightMove(state board, balance bool) board {
+	agent := positiveWeightAgent()
+	if balance {
+		agent = balanceWeightAgent()
+	}
+	return agent.playRound(nextBoardsChannel(state))
+}
+
+// LookaheadMove is the weight agent choice with lookahead moves for tests,
+// from the sequences listed by a game unless they are nil.
+func LookaheadMove(state board, lookahead int, sequences [][]board) board {
+	agent := balanceWeightAgent().configure(agentModel{Lookahead: lookahead})
+	if sequences == nil {
+		return agent.playRound(nextBoardsChannel(state))
+	}
+	play, _, _ := sequencePlayer(agent)
+	return play(sequences)
+}
+
+// WeightScores are the weight agent root scores in input order for tests.
+func WeightScores(state board) []int {
+	var out []int
+	for scored := range balanceWeightAgent().evaluateBoards(nextBoardsChannel(state)) {
+		out = append(out, scored.score)
+	}
+	return out
+}
+
+// AgentMove is the choice of a registered agent for tests.
+func AgentMove(name string, state board) board {
+	return agents[name].playRound(nextBoardsChannel(state))
+}
+
+// SeededMove is the choice of a registered agent configured with a seed
+// for tests.
+func SeededMove(name string, state board, seed int64) board {
+	agent := agents[name]
+	if configurable, ok := agent.(configurableAgent); ok {
+		agent = configurable.configure(agentModel{Seed: seed, Iterations: 200, Deterministic: true})
+	}
+	return agent.playRound(nextBoardsChannel(state))
+}
+
+// MoveSeed is exported for tests.
+var MoveSeed = moveSeed
+
+// MCTSWorkers of the mcts agent configured for deterministic play or not
+// for tests.
+func MCTSWorkers(deterministic bool) int {
+	return agents["mcts-agent"].(configurableAgent).configure(agentModel{Iterations: 200, Deterministic: deterministic}).(mctsAgent).workers
+}
+
+// AgentSeed is the recorded seed of an agent for tests.
+func AgentSeed(ID uuid.UUID) int64 {
+	db := openDB()
+	defer closeDB(db)
+	var agent agentModel
+	if err := db.First(&agent, "id = ?", ID).Error; err != nil {
+		panic(err)
+	}
+	return agent.Seed
+}
+
+// WeightProfile is the profile of the balance or positive weights for
+// tests.
+func WeightProfile(name string, agent string, balance bool) EvaluationProfile {
+	weights := positiveWeightAgent()
+	if balance {
+		weights = balanceWeightAgent()
+	}
+	return weights.profile(name, agent)
+}
+
+// Agent that plays an empty board and counts its rounds.
+type emptyAgent struct{ rounds *int32 }
+
+func (agent emptyAgent) playRound(boards <-chan board) board {
+	for range boards {
+	}
+	atomic.AddInt32(agent.rounds, 1)
+	return board{}
+}
+
+// RegisterEmptyAgent registers an agent that always plays an empty board for
+// tests.
+func RegisterEmptyAgent(name string, rounds *int32) {
+	agents[name] = emptyAgent{rounds}
+}
+
+// UnregisterAgent removes a registered agent for tests.
+func UnregisterAgent(name string) {
+	delete(agents, name)
+}
+
+// PolyglotHashKeys is the Polyglot hash of a board with a key table for
+// tests.
+func PolyglotHashKeys(keys []uint64, state board, activeWhite bool) uint64 {
+	return polyglotHashKeys(keys, state, activeWhite)
+}
+
+// PolyglotHash is the Polyglot hash of a board with the loaded keys for
+// tests.
+func PolyglotHash(state board, activeWhite bool) uint64 {
+	return polyglotHash(state, activeWhite)
+}
+
+// UnloadBooks removes the Polyglot keys and registered books for tests.
+func UnloadBooks() {
+	polyglotKeys = nil
+	books = map[string]OpeningBook{}
+}
+
+// BookMove is the choice of a registered agent behind a registered book for
+// tests.
+func BookMove(book string, name string, state board, activeWhite bool, seed int64) board {
+	model := agentModel{Seed: seed, Iterations: 200, Book: book}
+	agent := agents[name]
+	if configurable, ok := agent.(configurableAgent); ok {
+		agent = configurable.configure(model)
+	}
+	return model.bookLayer(agent, state, activeWhite).playRound(nextBoardsChannel(state))
+}
+
+// TaperedEvaluate is the tapered evaluation of a board for tests.
+func TaperedEvaluate(state board) int {
+	return newTaperedEvaluator().evaluate(state)
+}
+
+// DelegateEvaluate is the static value of a search or mcts delegate
+// configured with an evaluator for tests.
+func DelegateEvaluate(name string, evaluator string, state board) int {
+	switch agent := agents[name].(configurableAgent).configure(agentModel{Evaluator: evaluator}).(type) {
+	case searchAgent:
+		return agent.evaluate(state)
+	case mctsAgent:
+		return agent.eval.evaluate(state)
+	}
+	panic("not an evaluating delegate " + name)
+}
+
+// State of a tuning position for tests.
+func (position TuningPosition) State() board {
+	return position.state
+}
+
+// Result of a tuning position for tests.
+func (position TuningPosition) Result() float64 {
+	return position.result
+}
+
+// Strategies of the strategy agents for tests.
+var (
+	HarmonicPositive = harmonicPositive
+	MinValue         = minValue
+	MaxValue         = maxValue
+)
+
+// SearchNodes counts the nodes of a depth search with the named features,
+// and a fresh table, for benchmarks.
+func SearchNodes(state board, depth int, features ...string) int {
+	agent := newSearchAgent(positiveWeightAgent(), depth)
+	agent.table = &transpositionTable{size: 1}
+	agent.features = searchFeatures{}
+	for _, feature := range features {
+		switch feature {
+		case "quiescence":
+			agent.features.quiescence = true
+		case "mvv-lva":
+			agent.features.mvvLva = true
+		case "killers":
+			agent.features.killers = true
+		case "history":
+			agent.features.history = true
+		}
+	}
+	s := search{searchAgent: agent}
+	s.root(nextBoards(state), depth)
+	return s.nodes
+}
+
+// OpeningBoard plays absolute moves from the initial board for tests.
+func OpeningBoard(moves []AbsoluteMoveMessage) board {
+	game := boardModel{State: initialBoard}
+	for _, move := range moves {
+		game = game.update(game.absoluteMove(move))
+	}
+	return game.State
+}
+
+// UserMove is the board after a user agent move for tests.
+func UserMove(state board, move [2][2]int) board {
+	return userMove(state, move)
+}
+
+// MoveBudget is the time budget of an agent clock for tests.
+func MoveBudget(clock int, increment int, remaining int) time.Duration {
+	return agentModel{Clock: clock, Increment: increment, Remaining: remaining}.moveBudget()
+}
+
+// RegisterAgent saves an agent with a notification secret for tests.
+func RegisterAgent(ID uuid.UUID, secret string) {
+	transaction(func(db *gorm.DB) {
+		db.Create(&agentModel{ID: ID, Delegate: "user-agent", Secret: secret})
+	})
+}
+
+// RegisterDelegate saves an agent with a delegate for tests.
+func RegisterDelegate(ID uuid.UUID, delegate string) {
+	transaction(func(db *gorm.DB) {
+		db.Create(&agentModel{ID: ID, Delegate: delegate})
+	})
+}
+
+// Sign is exported for tests.
+func Sign(secret string, body []byte) string {
+	return sign(secret, body)
+}
+
+// SetNotifyBackoff shortens notification retries for tests.
+func SetNotifyBackoff(backoff time.Duration) {
+	notifyBackoff = backoff
+}
+
+// WaitNotifications waits for background notifications.
+func WaitNotifications() {
+	notifications.Wait()
+}
@@ -3,10 +3,12 @@ module github.com/neuralknight/backend-models
 go 1.19
 
 require (
+	github.com/gorilla/websocket v1.5.0
 	github.com/jinzhu/gorm v1.9.16
 	github.com/leanovate/gopter v0.2.9
 	github.com/satori/go.uuid v1.2.0
 	github.com/sirupsen/logrus v1.9.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -1,15 +1,49 @@
 package models_test
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
 	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/gorilla/websocket"
 	_ "github.com/jinzhu/gorm/dialects/sqlite"
 	"github.com/leanovate/gopter"
 	"github.com/leanovate/gopter/gen"
 	"github.com/leanovate/gopter/prop"
+
+	models "github.com/neuralknight/backend-models"
+	uuid "github.com/satori/go.uuid"
 )
 
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "chess")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("CHESS_DB", filepath.Join(dir, "chess.db"))
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
 func TestBoard(t *testing.T) {
 	properties := gopter.NewProperties(nil)
 
@@ -31,6 +65,1889 @@ func TestBoard(t *testing.T) {
 	properties.TestingRun(t)
 }
 
+// Random boards with one king for each side.
+func genBoard() gopter.Gen {
+	pieces := []interface{}{uint8(0), uint8(0x1C), uint8(0x1D)}
+	for piece := uint8(2); piece < 14; piece++ {
+		if piece&0xE != 4 {
+			pieces = append(pieces, piece)
+		}
+	}
+	return gopter.CombineGens(
+		gen.SliceOfN(64, gen.OneConstOf(pieces...)),
+		gen.IntRange(0, 63),
+		gen.IntRange(0, 63),
+		gen.OneConstOf(uint8(4), uint8(0x14)),
+	).Map(func(values []interface{}) models.TestBoard {
+		var b models.TestBoard
+		for i, piece := range values[0].([]uint8) {
+			b[i/8][i%8] = piece
+		}
+		king := values[3].(uint8)
+		active := values[1].(int)
+		inactive := values[2].(int)
+		b[inactive/8][inactive%8] = king
+		if active != inactive {
+			b[active/8][active%8] = king | 1
+		}
+		return b
+	})
+}
+
+func TestBoardPersistence(t *testing.T) {
+	properties := gopter.NewProperties(nil)
+
+	properties.Property("text value scans back", prop.ForAll(
+		func(b models.TestBoard) bool {
+			value, err := b.Value()
+			if err != nil {
+				return false
+			}
+			var out models.TestBoard
+			if out.Scan(value) != nil {
+				return false
+			}
+			var raw models.TestBoard
+			if raw.Scan([]byte(value.(string))) != nil {
+				return false
+			}
+			return out == b && raw == b
+		},
+		genBoard(),
+	))
+
+	properties.Property("json round trips", prop.ForAll(
+		func(b models.TestBoard) bool {
+			data, err := json.Marshal(b)
+			if err != nil {
+				return false
+			}
+			var out models.TestBoard
+			return json.Unmarshal(data, &out) == nil && out == b
+		},
+		genBoard(),
+	))
+
+	properties.Property("compact value scans back exactly", prop.ForAll(
+		func(b models.TestBoard) bool {
+			b = b.Canonical()
+			value, err := models.TestCompactBoard(b).Value()
+			if err != nil {
+				return false
+			}
+			text := value.(string)
+			if len(text) != 64 {
+				return false
+			}
+			var out, raw models.TestBoard
+			if out.Scan(text) != nil || out.Scan([]byte(text)) != nil {
+				return false
+			}
+			data, err := b.MarshalBinary()
+			return err == nil && len(data) == 32 && raw.Scan(data) == nil && out == b && raw == b
+		},
+		genBoard(),
+	))
+
+	properties.Property("compact value rejects castling flags that can never be used", prop.ForAll(
+		func(b models.TestBoard) bool {
+			_, err := models.TestCompactBoard(b).Value()
+			return (err != nil) == (b != b.Canonical())
+		},
+		genBoard(),
+	))
+
+	properties.TestingRun(t)
+}
+
+func TestBoardScanErrors(t *testing.T) {
+	var b models.TestBoard
+	value, _ := models.InitialBoard.Value()
+	text := value.(string)
+	for _, cell := range []interface{}{
+		nil,
+		42,
+		"",
+		text[:len(text)-2],
+		text + ",0000000000000000",
+		"zz" + text[2:],
+		make([]byte, 31),
+	} {
+		if err := b.Scan(cell); err == nil {
+			t.Errorf("expected error scanning %#v", cell)
+		}
+	}
+	if err := json.Unmarshal([]byte(`["0000000000000000"]`), &b); err == nil {
+		t.Error("expected error decoding short board")
+	}
+	if err := b.Scan(text); err != nil || b != models.InitialBoard {
+		t.Error("failed to scan initial board", err)
+	}
+}
+
+func TestCompactColumns(t *testing.T) {
+	handler := models.NewHandler()
+	var text models.BoardCreatedMessage
+	request(t, handler, http.MethodPost, "/issue", nil, http.StatusCreated, &text)
+	models.SetCompactColumns(true)
+	defer models.SetCompactColumns(false)
+	var created models.BoardCreatedMessage
+	request(t, handler, http.MethodPost, "/issue", nil, http.StatusCreated, &created)
+	game := "/issue/" + created.ID.String()
+	if raw := models.StoredState(created.ID); len(raw) != 64 {
+		t.Fatal("expected a compact state column", len(raw))
+	}
+	var state models.AbsoluteStateMessage
+	request(t, handler, http.MethodPut, game+"/board", models.AbsoluteMoveMessage{From: "e2", To: "e4"}, http.StatusOK, &state)
+	if state.Invalid || len(models.StoredState(created.ID)) != 64 {
+		t.Fatal("expected the move stored compact", state)
+	}
+	request(t, handler, http.MethodGet, game+"/board", nil, http.StatusOK, &state)
+	if state.Pieces["e4"].Piece != "pawn" || state.Pieces["e1"].Piece != "king" || state.MoveCount != 1 {
+		t.Error("unexpected state after reload", state.Pieces)
+	}
+
+	// Castling rights survive the round trip until the king moves.
+	var stored models.BoardStateMessage
+	request(t, handler, http.MethodGet, game, nil, http.StatusOK, &stored)
+	if stored.State != models.OpeningBoard([]models.AbsoluteMoveMessage{{From: "e2", To: "e4"}}) {
+		t.Error("expected castling rights kept", stored.State)
+	}
+	request(t, handler, http.MethodPut, game+"/board", models.AbsoluteMoveMessage{From: "e7", To: "e5"}, http.StatusOK, &state)
+	request(t, handler, http.MethodPut, game+"/board", models.AbsoluteMoveMessage{From: "e1", To: "e2"}, http.StatusOK, &state)
+	if state.Invalid {
+		t.Fatal("expected the king move stored", state)
+	}
+	request(t, handler, http.MethodGet, game, nil, http.StatusOK, &stored)
+	flagged := map[uint8]int{}
+	for _, r := range stored.State {
+		for _, piece := range r {
+			if piece&0x10 != 0 {
+				flagged[piece&1]++
+			}
+		}
+	}
+	if flagged[0] != 0 || flagged[1] != 3 {
+		t.Error("expected only black castling rights kept", flagged)
+	}
+	var unused models.TestBoard
+	unused[0][0], unused[7][4] = 0x1C, 0x15
+	if _, err := unused.MarshalBinary(); err == nil {
+		t.Error("expected a rook flag without its king rejected")
+	}
+	unused[0][0] = 0x3C
+	if _, err := unused.MarshalBinary(); err == nil {
+		t.Error("expected unknown piece bits rejected")
+	}
+
+	// Text rows stay readable.
+	var old models.BoardStateMessage
+	request(t, handler, http.MethodGet, "/issue/"+text.ID.String(), nil, http.StatusOK, &old)
+	if old.State != models.InitialBoard {
+		t.Error("expected the text row read back", old.State)
+	}
+}
+
+func TestMakeGameConcurrent(t *testing.T) {
+	const count = 64
+	ids := make(chan uuid.UUID, count)
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ids <- models.MakeGame(nil).ID
+		}()
+	}
+	wg.Wait()
+	close(ids)
+	seen := make(map[uuid.UUID]bool)
+	for ID := range ids {
+		if ID.Version() != uuid.V4 {
+			t.Error("unexpected ID version", ID)
+		}
+		if seen[ID] {
+			t.Error("duplicate game ID", ID)
+		}
+		seen[ID] = true
+		models.GetGame(ID)
+	}
+	for _, ID := range models.GetGames(nil).Games {
+		delete(seen, ID)
+	}
+	if len(seen) != 0 {
+		t.Error("games missing from listing", seen)
+	}
+}
+
+func jsonDecoder(t *testing.T, v interface{}) *json.Decoder {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return json.NewDecoder(bytes.NewReader(data))
+}
+
+// Run fn and report the value it panicked with.
+func recovered(fn func()) (r interface{}) {
+	defer func() {
+		r = recover()
+	}()
+	fn()
+	return nil
+}
+
+func TestConcurrentJoin(t *testing.T) {
+	ID := models.MakeGame(nil).ID
+	first := models.GetGame(ID)
+	second := models.GetGame(ID)
+	first.AddPlayer(jsonDecoder(t, models.GameJoinMessage{ID: uuid.NewV4()}))
+	r := recovered(func() {
+		second.AddPlayer(jsonDecoder(t, models.GameJoinMessage{ID: uuid.NewV4()}))
+	})
+	if _, ok := r.(models.StaleGame); !ok {
+		t.Error("expected stale game, got", r)
+	}
+}
+
+func TestConcurrentMoves(t *testing.T) {
+	ID := models.MakeGame(nil).ID
+	games := []models.Board{models.GetGame(ID), models.GetGame(ID)}
+	state := models.InitialBoard
+	state[6][4] = 0
+	state[4][4] = 9
+	var wg sync.WaitGroup
+	results := make(chan interface{}, len(games))
+	for _, game := range games {
+		wg.Add(1)
+		go func(game models.Board) {
+			defer wg.Done()
+			results <- recovered(func() {
+				message := game.PlayRound(jsonDecoder(t, models.PlayMessage{State: state}))
+				if message.Invalid || message.End {
+					t.Error("unexpected move result", message)
+				}
+			})
+		}(game)
+	}
+	wg.Wait()
+	close(results)
+	stale := 0
+	for r := range results {
+		if _, ok := r.(models.StaleGame); ok {
+			stale++
+		} else if r != nil {
+			t.Error("unexpected panic", r)
+		}
+	}
+	if stale != 1 {
+		t.Error("expected exactly one stale move, got", stale)
+	}
+	saved := models.GetGame(ID).GetState(nil).State
+	if saved[3][3] != 8 || saved[1][3] != 0 {
+		t.Error("move not saved", saved)
+	}
+	invalid := saved
+	invalid[6][0] = 0
+	invalid[3][0] = 9
+	if message := models.GetGame(ID).PlayRound(jsonDecoder(t, models.PlayMessage{State: invalid})); !message.Invalid {
+		t.Error("expected invalid move", message)
+	}
+}
+
+func request(t *testing.T, handler http.Handler, method, target string, body interface{}, status int, out interface{}) {
+	var reader *bytes.Reader
+	if body == nil {
+		reader = bytes.NewReader(nil)
+	} else if raw, ok := body.(string); ok {
+		reader = bytes.NewReader([]byte(raw))
+	} else {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(method, target, reader))
+	if recorder.Code != status {
+		t.Fatalf("%s %s: expected status %d, got %d: %s", method, target, status, recorder.Code, recorder.Body)
+	}
+	if out != nil {
+		if err := json.NewDecoder(recorder.Body).Decode(out); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// PUT a round to an agent signed with its secret.
+func playAgent(t *testing.T, handler http.Handler, agent models.AgentCreatedMessage, body interface{}, status int, out interface{}) {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(http.MethodPut, "/agent/"+agent.ID.String(), bytes.NewReader(data))
+	req.Header.Set(models.SignatureHeader, models.Sign(agent.Secret, data))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	if recorder.Code != status {
+		t.Fatalf("PUT agent %s: expected status %d, got %d: %s", agent.ID, status, recorder.Code, recorder.Body)
+	}
+	if out != nil {
+		if err := json.NewDecoder(recorder.Body).Decode(out); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	handler := models.NewHandler()
+	var created models.BoardCreatedMessage
+	request(t, handler, http.MethodPost, "/issue", nil, http.StatusCreated, &created)
+	game := "/issue/" + created.ID.String()
+
+	var games models.BoardStatesMessage
+	request(t, handler, http.MethodGet, "/issue", nil, http.StatusOK, &games)
+	found := false
+	for _, ID := range games.Games {
+		found = found || ID == created.ID
+	}
+	if !found {
+		t.Error("created game not listed")
+	}
+
+	var state models.BoardStateMessage
+	request(t, handler, http.MethodGet, game, nil, http.StatusOK, &state)
+	if state.State != models.InitialBoard || state.End {
+		t.Error("unexpected initial state", state)
+	}
+	request(t, handler, http.MethodPost, game, models.GameJoinMessage{ID: uuid.NewV4()}, http.StatusOK, nil)
+
+	var states models.CursorMessage
+	request(t, handler, http.MethodGet, game+"/states?lookahead=1", nil, http.StatusOK, &states)
+	if len(states.Boards) != 20 || states.Cursor != (uuid.UUID{}) {
+		t.Error("expected twenty opening moves", len(states.Boards))
+	}
+	request(t, handler, http.MethodGet, game+"/states?lookahead=2", nil, http.StatusOK, &states)
+	if len(states.Sequences) != 400 || len(states.Boards) != 0 || states.Cursor != (uuid.UUID{}) {
+		t.Error("expected four hundred sequences", len(states.Sequences))
+	}
+	for _, sequence := range states.Sequences {
+		if len(sequence) != 2 || sequence[0][6] == models.InitialBoard[6] && sequence[0][7] == models.InitialBoard[7] {
+			t.Fatal("expected a move and its reply", sequence)
+		}
+	}
+	// Deeper sequences are sent a slice at a time.
+	sequences := 0
+	cursor := ""
+	for {
+		var slice models.CursorMessage
+		request(t, handler, http.MethodGet, game+"/states?lookahead=3"+cursor, nil, http.StatusOK, &slice)
+		sequences += len(slice.Sequences)
+		if slice.Cursor == (uuid.UUID{}) {
+			break
+		}
+		cursor = "&cursor=" + slice.Cursor.String()
+	}
+	if sequences != 8902 {
+		t.Error("unexpected sequences three moves deep", sequences)
+	}
+	request(t, handler, http.MethodGet, game+"/states?lookahead=4", nil, http.StatusBadRequest, nil)
+	request(t, handler, http.MethodGet, game+"/states?lookahead=0", nil, http.StatusBadRequest, nil)
+
+	next := models.InitialBoard
+	next[6][3] = 0
+	next[5][3] = 9
+	request(t, handler, http.MethodPut, game, models.PlayMessage{State: next}, http.StatusOK, &state)
+	if state.Invalid || state.State[2][4] != 8 {
+		t.Error("move not applied", state)
+	}
+	request(t, handler, http.MethodPut, game, models.PlayMessage{State: next}, http.StatusOK, &state)
+	if !state.Invalid {
+		t.Error("expected invalid move", state)
+	}
+
+	var failure models.ErrorMessage
+	request(t, handler, http.MethodPut, game, "{", http.StatusBadRequest, &failure)
+	if failure.Error == "" {
+		t.Error("expected error body")
+	}
+	request(t, handler, http.MethodGet, "/issue/"+uuid.NewV4().String(), nil, http.StatusNotFound, &failure)
+	request(t, handler, http.MethodGet, "/issue/not-a-game", nil, http.StatusNotFound, &failure)
+	request(t, handler, http.MethodGet, "/agent/"+uuid.NewV4().String(), nil, http.StatusNotFound, &failure)
+	request(t, handler, http.MethodDelete, game, nil, http.StatusMethodNotAllowed, &failure)
+	request(t, handler, http.MethodGet, game+"/info", nil, http.StatusOK, nil)
+	request(t, handler, http.MethodGet, game+"/missing", nil, http.StatusNotFound, &failure)
+}
+
+func TestBoardPrint(t *testing.T) {
+	start := models.InitialBoard
+	if printed := start.Print("emoji", true); printed != `♜♞♝♛♚♝♞♜
+♟♟♟♟♟♟♟♟
+▪▫▪▫▪▫▪▫
+▫▪▫▪▫▪▫▪
+▪▫▪▫▪▫▪▫
+▫▪▫▪▫▪▫▪
+♙♙♙♙♙♙♙♙
+♖♘♗♕♔♗♘♖` {
+		t.Error("unexpected emoji board\n" + printed)
+	}
+	var end models.TestBoard
+	end[3][3] = 4
+	end[4][6] = 11
+	end[5][3] = 5
+	if printed := end.Print("emoji", true); printed != `▪▫▪▫▪▫▪▫
+▫▪▫▪▫▪▫▪
+▪▫▪▫▪▫▪▫
+▫▪▫♚▫▪▫▪
+▪▫▪▫▪▫♕▫
+▫▪▫♔▫▪▫▪
+▪▫▪▫▪▫▪▫
+▫▪▫▪▫▪▫▪` {
+		t.Error("unexpected emoji end board\n" + printed)
+	}
+	if printed := start.Print("ascii", false); printed != `RNBQKBNR
+PPPPPPPP
+........
+........
+........
+........
+pppppppp
+rnbqkbnr` {
+		t.Error("unexpected ascii board\n" + printed)
+	}
+
+	handler := models.NewHandler()
+	var created models.BoardCreatedMessage
+	request(t, handler, http.MethodPost, "/issue", nil, http.StatusCreated, &created)
+	game := "/issue/" + created.ID.String()
+	next := models.InitialBoard
+	next[6][4] = 0
+	next[4][4] = 9
+	request(t, handler, http.MethodPut, game, models.PlayMessage{State: next}, http.StatusOK, nil)
+	var info models.BoardInfoMessage
+	request(t, handler, http.MethodGet, game+"/info?style=unicode&side=white", nil, http.StatusOK, &info)
+	if info.Print != `8 ♜ ♞ ♝ ♛ ♚ ♝ ♞ ♜
+7 ♟ ♟ ♟ ♟ ♟ ♟ ♟ ♟
+6 · · · · · · · ·
+5 · · · · · · · ·
+4 · · · · ♙ · · ·
+3 · · · · · · · ·
+2 ♙ ♙ ♙ ♙ · ♙ ♙ ♙
+1 ♖ ♘ ♗ ♕ ♔ ♗ ♘ ♖
+  a b c d e f g h` {
+		t.Error("unexpected unicode board\n" + info.Print)
+	}
+	request(t, handler, http.MethodGet, game+"/info?style=unicode", nil, http.StatusOK, &info)
+	if info.Print != `1 ♖ ♘ ♗ ♔ ♕ ♗ ♘ ♖
+2 ♙ ♙ ♙ · ♙ ♙ ♙ ♙
+3 · · · · · · · ·
+4 · · · ♙ · · · ·
+5 · · · · · · · ·
+6 · · · · · · · ·
+7 ♟ ♟ ♟ ♟ ♟ ♟ ♟ ♟
+8 ♜ ♞ ♝ ♚ ♛ ♝ ♞ ♜
+  h g f e d c b a` {
+		t.Error("unexpected unicode board for black\n" + info.Print)
+	}
+	request(t, handler, http.MethodGet, game+"/info?style=braille", nil, http.StatusBadRequest, nil)
+}
+
+func TestAbsoluteBoard(t *testing.T) {
+	handler := models.NewHandler()
+	var created models.BoardCreatedMessage
+	request(t, handler, http.MethodPost, "/issue", nil, http.StatusCreated, &created)
+	game := "/issue/" + created.ID.String() + "/board"
+
+	var state models.AbsoluteStateMessage
+	request(t, handler, http.MethodGet, game, nil, http.StatusOK, &state)
+	if state.Active != "white" || state.State != models.InitialBoard || len(state.Pieces) != 32 {
+		t.Error("unexpected initial board", state)
+	}
+	if king := state.Pieces["e1"]; king.Colour != "white" || king.Piece != "king" {
+		t.Error("expected white king on e1", king)
+	}
+
+	move := func(from, to string) (state models.AbsoluteStateMessage) {
+		request(t, handler, http.MethodPut, game, models.AbsoluteMoveMessage{From: from, To: to}, http.StatusOK, &state)
+		return state
+	}
+	if state := move("e2", "e4"); state.Invalid || state.Active != "black" || state.Pieces["e4"].Colour != "white" {
+		t.Error("white move not applied", state)
+	}
+	if state := move("d7", "d4"); !state.Invalid || state.Active != "black" {
+		t.Error("expected invalid black move", state)
+	}
+	state = move("d7", "d5")
+	if state.Invalid || state.Active != "white" || state.MoveCount != 2 {
+		t.Error("black move not applied", state)
+	}
+	if pawn := state.Pieces["d5"]; pawn.Colour != "black" || pawn.Piece != "pawn" {
+		t.Error("expected black pawn on d5", pawn)
+	}
+	if _, ok := state.Pieces["d7"]; ok {
+		t.Error("expected d7 to be empty")
+	}
+	if state := move("e4", "d5"); state.Invalid || state.Pieces["d5"].Colour != "white" || len(state.Pieces) != 31 {
+		t.Error("capture not applied", state)
+	}
+	// Pawns reaching the last rank promote to queens unless told otherwise.
+	for _, squares := range [][2]string{{"c7", "c6"}, {"d5", "c6"}, {"g8", "f6"}, {"c6", "b7"}, {"c8", "g4"}} {
+		if state := move(squares[0], squares[1]); state.Invalid {
+			t.Fatal("move not applied", squares)
+		}
+	}
+	if state := move("b7", "a8"); state.Invalid || state.Pieces["a8"].Colour != "white" || state.Pieces["a8"].Piece != "queen" {
+		t.Error("expected a white queen on a8", state.Pieces["a8"])
+	}
+	request(t, handler, http.MethodPut, game, models.AbsoluteMoveMessage{From: "z9", To: "d5"}, http.StatusBadRequest, nil)
+}
+
+func TestPromotion(t *testing.T) {
+	var state models.TestBoard
+	state[7][4], state[0][4], state[1][0] = 5, 4, 9
+	for piece := uint8(0); piece < 0x10; piece++ {
+		next := state
+		next[1][0], next[0][0] = 0, piece
+		valid := piece == 3 || piece == 7 || piece == 11 || piece == 13
+		if models.ValidUpdate(state, next) != valid {
+			t.Error("unexpected promotion validity", piece, valid)
+		}
+	}
+}
+
+func dialSocket(t *testing.T, server *httptest.Server, path string) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func readEvent(t *testing.T, conn *websocket.Conn) (event models.GameEventMessage) {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&event); err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+func TestGameSocket(t *testing.T) {
+	server := httptest.NewServer(models.NewHandler())
+	defer server.Close()
+	var created models.BoardCreatedMessage
+	request(t, server.Config.Handler, http.MethodPost, "/issue", nil, http.StatusCreated, &created)
+	game := "/issue/" + created.ID.String() + "/socket"
+	white, black := uuid.NewV4(), uuid.NewV4()
+	for _, player := range []uuid.UUID{white, black} {
+		models.RegisterAgent(player, player.String()+"-secret")
+		request(t, server.Config.Handler, http.MethodPost, "/issue/"+created.ID.String(), models.GameJoinMessage{ID: player}, http.StatusOK, nil)
+	}
+	signature := func(player uuid.UUID) string {
+		return models.Sign(player.String()+"-secret", []byte(created.ID.String()))
+	}
+
+	first := dialSocket(t, server, game+"?player="+white.String()+"&signature="+signature(white))
+	defer first.Close()
+	if event := readEvent(t, first); event.Type != "state" || event.State != models.InitialBoard {
+		t.Fatal("expected initial state", event)
+	}
+
+	// Player IDs are public, acting for one needs its signature.
+	socketURL := "ws" + strings.TrimPrefix(server.URL, "http") + game
+	for _, query := range []string{
+		"?player=" + white.String(),
+		"?player=" + white.String() + "&signature=" + signature(black),
+		"?player=" + uuid.NewV4().String() + "&signature=" + signature(white),
+	} {
+		if _, resp, err := websocket.DefaultDialer.Dial(socketURL+query, nil); err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+			t.Fatal("expected an unsigned player rejected", query, err)
+		}
+	}
+
+	// Spectators may neither move nor offer draws.
+	outsider := dialSocket(t, server, game)
+	defer outsider.Close()
+	readEvent(t, outsider)
+	for _, message := range []models.SocketMessage{{Type: "move", From: "e2", To: "e4"}, {Type: "draw"}} {
+		if err := outsider.WriteJSON(message); err != nil {
+			t.Fatal(err)
+		}
+		var reply models.ErrorMessage
+		outsider.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if err := outsider.ReadJSON(&reply); err != nil || reply.Error == "" {
+			t.Fatal("expected outsider rejected", message.Type, reply, err)
+		}
+	}
+
+	if err := first.WriteJSON(models.SocketMessage{Type: "move", From: "e2", To: "e4"}); err != nil {
+		t.Fatal(err)
+	}
+	move := readEvent(t, first)
+	if move.Type != "move" || move.MoveCount != 1 || move.Player != white {
+		t.Fatal("expected move event", move)
+	}
+	// White may not move on the black turn.
+	if err := first.WriteJSON(models.SocketMessage{Type: "move", From: "d2", To: "d4"}); err != nil {
+		t.Fatal(err)
+	}
+	var reply models.ErrorMessage
+	first.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := first.ReadJSON(&reply); err != nil || reply.Error == "" {
+		t.Fatal("expected move out of turn rejected", reply, err)
+	}
+
+	second := dialSocket(t, server, game+"?since="+strconv.Itoa(move.ID-1)+"&player="+black.String()+"&signature="+signature(black))
+	defer second.Close()
+	if event := readEvent(t, second); event.Type != "move" || event.ID != move.ID || event.State != move.State {
+		t.Fatal("expected replayed move", event)
+	}
+	if event := readEvent(t, second); event.Type != "state" || event.ID != move.ID {
+		t.Fatal("expected current state", event)
+	}
+
+	if err := first.WriteJSON(models.SocketMessage{Type: "draw"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, conn := range []*websocket.Conn{first, second} {
+		if event := readEvent(t, conn); event.Type != "draw" || event.Player != white {
+			t.Fatal("expected draw offer", event)
+		}
+	}
+	// A repeated offer by the same player does not agree the draw.
+	if err := first.WriteJSON(models.SocketMessage{Type: "draw"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, conn := range []*websocket.Conn{first, second} {
+		if event := readEvent(t, conn); event.Type != "draw" || event.Player != white {
+			t.Fatal("expected repeated draw offer", event)
+		}
+	}
+	if err := second.WriteJSON(models.SocketMessage{Type: "draw"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, conn := range []*websocket.Conn{first, second} {
+		if event := readEvent(t, conn); event.Type != "end" || event.Reason != "draw agreed" {
+			t.Fatal("expected draw", event)
+		}
+	}
+	var state models.BoardStateMessage
+	request(t, server.Config.Handler, http.MethodGet, "/issue/"+created.ID.String(), nil, http.StatusOK, &state)
+	if !state.End {
+		t.Error("expected game to end")
+	}
+}
+
+type eventReader struct {
+	t       *testing.T
+	body    *bufio.Reader
+	closer  func() error
+	timeout *time.Timer
+}
+
+func openEvents(t *testing.T, server *httptest.Server, path string, last string) eventReader {
+	req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last != "" {
+		req.Header.Set("Last-Event-ID", last)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatal("unexpected response", resp.Status, resp.Header)
+	}
+	return eventReader{t, bufio.NewReader(resp.Body), resp.Body.Close, time.AfterFunc(10*time.Second, func() { resp.Body.Close() })}
+}
+
+// Read the next event and its ID.
+func (reader eventReader) next() (string, models.GameEventMessage) {
+	var ID, kind string
+	var event models.GameEventMessage
+	for {
+		line, err := reader.body.ReadString('\n')
+		if err != nil {
+			reader.t.Fatal(err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && kind != "":
+			if event.Type != kind {
+				reader.t.Fatal("event type mismatch", kind, event)
+			}
+			return ID, event
+		case strings.HasPrefix(line, "id: "):
+			ID = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			kind = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+				reader.t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestGameEvents(t *testing.T) {
+	server := httptest.NewServer(models.NewHandler())
+	defer server.Close()
+	handler := server.Config.Handler
+
+	feed := openEvents(t, server, "/issue/events", "")
+	defer feed.closer()
+	var created models.BoardCreatedMessage
+	request(t, handler, http.MethodPost, "/issue", nil, http.StatusCreated, &created)
+	if ID, event := feed.next(); event.Type != "create" || event.Game != created.ID || ID != created.ID.String() {
+		t.Fatal("expected created game", ID, event)
+	}
+	var later models.BoardCreatedMessage
+	request(t, handler, http.MethodPost, "/issue", nil, http.StatusCreated, &later)
+	resumed := openEvents(t, server, "/issue/events", created.ID.String())
+	defer resumed.closer()
+	if _, event := resumed.next(); event.Game != later.ID {
+		t.Fatal("expected resumed game creation", event)
+	}
+
+	game := "/issue/" + created.ID.String()
+	live := openEvents(t, server, game+"/events", "")
+	defer live.closer()
+	if _, event := live.next(); event.Type != "state" {
+		t.Fatal("expected state", event)
+	}
+	player := uuid.NewV4()
+	request(t, handler, http.MethodPost, game, models.GameJoinMessage{ID: player}, http.StatusOK, nil)
+	if _, event := live.next(); event.Type != "join" || event.Player != player {
+		t.Fatal("expected join", event)
+	}
+	request(t, handler, http.MethodPut, game+"/board", models.AbsoluteMoveMessage{From: "g1", To: "f3"}, http.StatusOK, nil)
+	ID, move := live.next()
+	if move.Type != "move" || move.Player != player || ID != strconv.Itoa(move.ID) {
+		t.Fatal("expected move", ID, move)
+	}
+
+	replay := openEvents(t, server, game+"/events", "0")
+	defer replay.closer()
+	for _, kind := range []string{"join", "move", "state"} {
+		if _, event := replay.next(); event.Type != kind {
+			t.Fatal("expected replayed", kind, event)
+		}
+	}
+}
+
+func TestPokePlayer(t *testing.T) {
+	models.SetNotifyBackoff(time.Millisecond)
+	agent, secret := uuid.NewV4(), "secret"
+	models.RegisterAgent(agent, secret)
+	var calls int32
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !models.VerifySignature(secret, body, r.Header.Get(models.SignatureHeader)) {
+			t.Error("invalid signature")
+		}
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var state models.BoardStateMessage
+		if err := json.Unmarshal(body, &state); err != nil || state.State != models.InitialBoard {
+			t.Error("unexpected notification", string(body), err)
+		}
+	}))
+	defer callback.Close()
+
+	handler := models.NewHandler()
+	var created models.BoardCreatedMessage
+	request(t, handler, http.MethodPost, "/issue", nil, http.StatusCreated, &created)
+	game := "/issue/" + created.ID.String()
+	request(t, handler, http.MethodPost, game, models.GameJoinMessage{ID: agent, AgentURL: "not a url"}, http.StatusBadRequest, nil)
+	request(t, handler, http.MethodPost, game, models.GameJoinMessage{ID: agent, AgentURL: callback.URL}, http.StatusOK, nil)
+	request(t, handler, http.MethodPost, game, models.GameJoinMessage{ID: uuid.NewV4(), AgentURL: "http://127.0.0.1:1/unreachable"}, http.StatusOK, nil)
+	models.WaitNotifications()
+
+	// Black has an unreachable callback, the move still succeeds.
+	var state models.BoardStateMessage
+	request(t, handler, http.MethodPut, game+"/board", models.AbsoluteMoveMessage{From: "e2", To: "e4"}, http.StatusOK, &state)
+	if state.Invalid {
+		t.Error("move failed", state)
+	}
+	models.WaitNotifications()
+
+	var log models.DeliveriesMessage
+	request(t, handler, http.MethodGet, game+"/deliveries", nil, http.StatusOK, &log)
+	statuses := make([]int, 0)
+	failures := 0
+	for _, delivery := range log.Deliveries {
+		if delivery.Player == agent {
+			statuses = append(statuses, delivery.Status)
+		} else if delivery.Error != "" {
+			failures++
+		}
+	}
+	if len(statuses) != 3 || statuses[0] != http.StatusServiceUnavailable || statuses[2] != http.StatusOK {
+		t.Error("unexpected deliveries", statuses)
+	}
+	if failures != 5 {
+		t.Error("expected five failed deliveries to black, got", failures)
+	}
+}
+
+func TestStatesPlayed(t *testing.T) {
+	// Cursor boards are played as they are listed.
+	handler := models.NewHandler()
+	var created models.BoardCreatedMessage
+	request(t, handler, http.MethodPost, "/issue", nil, http.StatusCreated, &created)
+	game := "/issue/" + created.ID.String()
+	var states models.CursorMessage
+	request(t, handler, http.MethodGet, game+"/states", nil, http.StatusOK, &states)
+	if len(states.Boards) != 20 {
+		t.Fatal("expected twenty opening moves", len(states.Boards))
+	}
+	var state models.BoardStateMessage
+	request(t, handler, http.MethodPut, game, models.PlayMessage{State: states.Boards[0]}, http.StatusOK, &state)
+	if state.Invalid {
+		t.Error("cursor board rejected", states.Boards[0])
+	}
+}
+
+func TestUserMove(t *testing.T) {
+	var state models.TestBoard
+	state[7][4], state[0][4], state[1][0], state[2][7] = 5, 4, 9, 13
+	if next := models.UserMove(state, [2][2]int{{1, 0}, {0, 0}}); next[0][0] != 11 || next[1][0] != 0 {
+		t.Error("expected the pawn promoted to a queen", next[0][0])
+	}
+	if next := models.UserMove(state, [2][2]int{{2, 7}, {0, 7}}); next[0][7] != 13 {
+		t.Error("expected the rook kept", next[0][7])
+	}
+	if next := models.UserMove(state, [2][2]int{{1, 0}, {0, 1}}); next[0][1] != 11 {
+		t.Error("expected a capturing pawn promoted", next[0][1])
+	}
+}
+
+func TestGameClient(t *testing.T) {
+	handler := models.NewHandler()
+	defer func(transport http.RoundTripper) { models.DefaultGameClient.Transport = transport }(models.DefaultGameClient.Transport)
+	models.DefaultGameClient.Transport = models.LocalTransport(handler)
+
+	var created models.BoardCreatedMessage
+	request(t, handler, http.MethodPost, "/issue", nil, http.StatusCreated, &created)
+	gameURL := "http://local/issue/" + created.ID.String()
+	client, err := models.NewGameClient(gameURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	states, err := client.States(ctx, uuid.UUID{})
+	if err != nil || len(states.Boards) != 20 || states.Cursor != (uuid.UUID{}) {
+		t.Fatal("expected twenty opening moves", len(states.Boards), err)
+	}
+
+	// Both players join through the in process transport.
+	var white, black models.AgentCreatedMessage
+	request(t, handler, http.MethodPost, "/agent", models.AgentCreateMessage{User: true, GameURL: gameURL}, http.StatusCreated, &white)
+	request(t, handler, http.MethodPost, "/agent", models.AgentCreateMessage{User: true, GameURL: gameURL}, http.StatusCreated, &black)
+	request(t, handler, http.MethodPost, "/agent", models.AgentCreateMessage{User: true, GameURL: "relative"}, http.StatusBadRequest, nil)
+	var state models.BoardStateMessage
+	move := models.UserMoveMessage{Move: [2][2]int{{6, 4}, {4, 4}}}
+	request(t, handler, http.MethodPut, "/agent/"+white.ID.String(), move, http.StatusUnauthorized, nil)
+	playAgent(t, handler, models.AgentCreatedMessage{ID: white.ID, Secret: black.Secret}, move, http.StatusUnauthorized, nil)
+	playAgent(t, handler, white, move, http.StatusOK, &state)
+	if state.Invalid || state.State[3][3] != 8 {
+		t.Error("move not applied", state)
+	}
+	if state, err = client.State(ctx); err != nil || state.State[3][3] != 8 {
+		t.Error("unexpected state", state, err)
+	}
+	if _, err = client.Join(ctx, models.GameJoinMessage{ID: uuid.NewV4()}); err == nil || err.(models.GameServerError).Status != http.StatusConflict {
+		t.Error("expected full game", err)
+	}
+
+	// Only idempotent requests are retried.
+	var calls int32
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1)%3 != 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(models.BoardStateMessage{End: true})
+	}))
+	defer flaky.Close()
+	client, _ = models.NewGameClient(flaky.URL + "/issue/game")
+	client.Transport = http.DefaultTransport
+	client.Backoff = time.Millisecond
+	if state, err = client.State(ctx); err != nil || !state.End || calls != 3 {
+		t.Error("expected retried state", calls, err)
+	}
+	if _, err = client.Play(ctx, models.InitialBoard); err == nil || calls != 4 {
+		t.Error("expected single move attempt", calls, err)
+	}
+
+	// Timeouts and cancellation stop waiting on the server.
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	client, _ = models.NewGameClient(slow.URL + "/issue/game")
+	client.Transport = http.DefaultTransport
+	client.Timeout = 10 * time.Millisecond
+	client.Retries = 0
+	if _, err = client.State(ctx); err != context.DeadlineExceeded {
+		t.Error("expected timeout", err)
+	}
+	client.Timeout = 0
+	cancelled, cancel := context.WithCancel(ctx)
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if _, err = client.State(cancelled); err != context.Canceled {
+		t.Error("expected cancellation", err)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	if _, err := (models.Match{White: "base-agent", Black: "missing"}).Play(); err == nil {
+		t.Error("expected unknown agent")
+	}
+	result, err := models.Match{White: "base-agent", Black: "base-agent", MoveLimit: 60}.Play()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Moves) != len(result.MoveTimes) || len(result.Moves) == 0 || len(result.Moves) > 60 {
+		t.Fatal("unexpected move list", len(result.Moves), len(result.MoveTimes))
+	}
+	if result.Reason == "move limit" && (len(result.Moves) != 60 || result.Winner != "") {
+		t.Error("unexpected move limit result", result)
+	}
+
+	opening := models.RandomOpening(6, rand.New(rand.NewSource(1)))
+	result, err = models.Match{White: "base-agent", Black: "base-agent", Opening: opening, MoveLimit: 10}.Play()
+	if err != nil || len(opening) != 6 || len(result.Moves) != 10 || result.Moves[5] != opening[5] {
+		t.Error("opening not played", opening, result.Moves, err)
+	}
+	opening[0].To = "e5"
+	if _, err = (models.Match{White: "base-agent", Black: "base-agent", Opening: opening}).Play(); err == nil {
+		t.Error("expected illegal opening")
+	}
+
+	// The same moves are accepted by the game server.
+	handler := models.NewHandler()
+	var created models.BoardCreatedMessage
+	request(t, handler, http.MethodPost, "/issue", nil, http.StatusCreated, &created)
+	game := "/issue/" + created.ID.String()
+	for _, move := range result.Moves {
+		var state models.AbsoluteStateMessage
+		request(t, handler, http.MethodPut, game+"/board", move, http.StatusOK, &state)
+		if state.Invalid {
+			t.Fatal("match move rejected", move)
+		}
+	}
+	var final models.AbsoluteStateMessage
+	request(t, handler, http.MethodGet, game+"/board", nil, http.StatusOK, &final)
+	if final.State != result.State {
+		t.Error("final state differs")
+	}
+}
+
+func TestRatings(t *testing.T) {
+	handler := models.NewHandler()
+	white, black := uuid.NewV4(), uuid.NewV4()
+	whiteDelegate, blackDelegate := "white-"+white.String(), "black-"+black.String()
+	models.RegisterDelegate(white, whiteDelegate)
+	models.RegisterDelegate(black, blackDelegate)
+	for game := 0; game < 2; game++ {
+		var created models.BoardCreatedMessage
+		request(t, handler, http.MethodPost, "/issue", nil, http.StatusCreated, &created)
+		path := "/issue/" + created.ID.String()
+		request(t, handler, http.MethodPost, path, models.GameJoinMessage{ID: white}, http.StatusOK, nil)
+		request(t, handler, http.MethodPost, path, models.GameJoinMessage{ID: black}, http.StatusOK, nil)
+		// Black ignores the check and white takes the king.
+		for _, move := range [][2]string{{"e2", "e4"}, {"f7", "f6"}, {"d1", "h5"}, {"g7", "g5"}, {"h5", "e8"}} {
+			var state models.AbsoluteStateMessage
+			request(t, handler, http.MethodPut, path+"/board", models.AbsoluteMoveMessage{From: move[0], To: move[1]}, http.StatusOK, &state)
+			if state.Invalid {
+				t.Fatal("move rejected", move)
+			}
+		}
+	}
+
+	var history models.RatingHistoryMessage
+	request(t, handler, http.MethodGet, "/ratings/agent/"+white.String(), nil, http.StatusOK, &history)
+	if history.Rating.Games != 2 || history.Rating.Wins != 2 || len(history.History) != 2 {
+		t.Fatal("unexpected history", history)
+	}
+	if history.History[0].Before != 1500 || history.History[0].After != 1516 || history.History[1].Before != 1516 || history.History[1].After != history.Rating.Rating {
+		t.Error("unexpected rating changes", history.History)
+	}
+	var loser models.RatingHistoryMessage
+	request(t, handler, http.MethodGet, "/ratings/delegate/"+blackDelegate, nil, http.StatusOK, &loser)
+	if loser.Rating.Losses != 2 || loser.Rating.Rating != 3000-history.Rating.Rating {
+		t.Error("unexpected delegate rating", loser.Rating)
+	}
+
+	var leaderboard models.LeaderboardMessage
+	request(t, handler, http.MethodGet, "/ratings?kind=delegate&limit=1000", nil, http.StatusOK, &leaderboard)
+	rank := map[string]int{}
+	for i, rating := range leaderboard.Ratings {
+		rank[rating.Name] = i + 1
+		if i > 0 && rating.Rating > leaderboard.Ratings[i-1].Rating {
+			t.Error("leaderboard not sorted")
+		}
+	}
+	if rank[whiteDelegate] == 0 || rank[blackDelegate] == 0 || rank[whiteDelegate] > rank[blackDelegate] {
+		t.Error("unexpected leaderboard", rank[whiteDelegate], rank[blackDelegate])
+	}
+	request(t, handler, http.MethodGet, "/ratings?kind=nobody", nil, http.StatusBadRequest, nil)
+	request(t, handler, http.MethodGet, "/ratings/agent/"+uuid.NewV4().String(), nil, http.StatusNotFound, nil)
+}
+
+func TestSearchAgent(t *testing.T) {
+	// The pawn on d5 is defended from e6, quiescence sees the recapture.
+	var trap models.TestBoard
+	trap[7][4], trap[7][3] = 5, 11
+	trap[0][4], trap[3][3], trap[2][4] = 4, 8, 8
+	for depth := 1; depth <= 2; depth++ {
+		if move := models.SearchMove(trap, depth); move[3][3] == 11 {
+			t.Error("expected the defended pawn to be left", depth, move)
+		}
+	}
+	// The knight on a4 is free.
+	trap[4][0] = 6
+	if move := models.SearchMove(trap, 1); move[4][0] != 11 {
+		t.Error("expected the free knight taken", move)
+	}
+
+	// Back rank mate.
+	var mate models.TestBoard
+	mate[7][6], mate[7][0], mate[6][5], mate[6][6], mate[6][7] = 5, 13, 9, 9, 9
+	mate[0][6], mate[1][5], mate[1][6], mate[1][7] = 4, 8, 8, 8
+	if move := models.SearchMove(mate, 2); move[0][0] != 13 {
+		t.Error("expected mate on the back rank", move)
+	}
+
+	// Iterative deepening finds the mate and answers within the budget.
+	if move := models.TimedSearchMove(mate, 50); move[0][0] != 13 {
+		t.Error("expected mate against the clock", move)
+	}
+	start := time.Now()
+	if move := models.TimedSearchMove(models.InitialBoard, 100); move == (models.TestBoard{}) {
+		t.Error("expected a move against the clock")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Error("search overran its budget", elapsed)
+	}
+	if budget := models.MoveBudget(60000, 1000, 60000); budget != 2750*time.Millisecond {
+		t.Error("unexpected clock budget", budget)
+	}
+	if budget := models.MoveBudget(60000, 2000, 1000); budget != 500*time.Millisecond {
+		t.Error("expected at most half the remaining clock", budget)
+	}
+
+	result, err := models.Match{White: "search-agent", Black: "base-agent", MoveLimit: 40}.Play()
+	if err != nil || result.Winner == "black" || result.Reason == "illegal move" {
+		t.Error("unexpected search agent result", result.Winner, result.Reason, err)
+	}
+
+	// Fixed depth searches stay shallow enough to answer, clocks allow more.
+	for _, test := range []struct{ lookahead, moveTime, depth int }{
+		{0, 0, 3}, {5, 0, 5}, {40, 0, 8}, {0, 100, 64}, {40, 100, 40}, {100, 100, 64},
+	} {
+		if depth := models.SearchDepth(test.lookahead, test.moveTime); depth != test.depth {
+			t.Error("unexpected search depth", test, depth)
+		}
+	}
+	handler := models.NewHandler()
+	for _, message := range []models.AgentCreateMessage{
+		{Delegate: "search-agent", Lookahead: -1},
+		{Delegate: "base-agent", Lookahead: -1},
+		{Delegate: "search-agent", Lookahead: 9},
+		{Delegate: "search-agent", Lookahead: 65, MoveTime: 100},
+	} {
+		message.GameURL = "http://local/issue/game"
+		request(t, handler, http.MethodPost, "/agent", message, http.StatusBadRequest, nil)
+	}
+}
+
+func TestTranspositionTable(t *testing.T) {
+	handler := models.NewHandler()
+	agent := uuid.NewV4()
+	models.RegisterDelegate(agent, "search-agent")
+	var before, after models.TableStatsMessage
+	request(t, handler, http.MethodGet, "/agent/"+agent.String()+"/table", nil, http.StatusOK, &before)
+	if before.Size != 16 || before.Entries == 0 || before.Probes != 0 {
+		t.Error("unexpected table", before)
+	}
+	models.AgentSearchMove(agent, models.InitialBoard, 4)
+	request(t, handler, http.MethodGet, "/agent/"+agent.String()+"/table", nil, http.StatusOK, &after)
+	if after.Probes <= before.Probes || after.Hits <= before.Hits || after.Stores <= before.Stores {
+		t.Error("expected table use", before, after)
+	}
+
+	// Other agents search with their own tables.
+	models.SearchMove(models.InitialBoard, 4)
+	models.AgentSearchMove(uuid.NewV4(), models.InitialBoard, 4)
+	var other models.TableStatsMessage
+	request(t, handler, http.MethodGet, "/agent/"+agent.String()+"/table", nil, http.StatusOK, &other)
+	if other != after {
+		t.Error("expected the table unshared", after, other)
+	}
+
+	// The least recently used tables are dropped to bound the memory.
+	for i := 0; i < 4; i++ {
+		models.ReserveTable(uuid.NewV4(), 256)
+	}
+	request(t, handler, http.MethodGet, "/agent/"+agent.String()+"/table", nil, http.StatusOK, &other)
+	if other.Probes != 0 {
+		t.Error("expected the table dropped", other)
+	}
+	request(t, handler, http.MethodPost, "/agent", models.AgentCreateMessage{GameURL: "http://local/issue/game", Delegate: "search-agent", HashSize: 257}, http.StatusBadRequest, nil)
+	request(t, handler, http.MethodPost, "/agent", models.AgentCreateMessage{GameURL: "http://local/issue/game", Delegate: "search-agent", HashSize: -1}, http.StatusBadRequest, nil)
+	request(t, handler, http.MethodGet, "/agent/"+uuid.NewV4().String()+"/table", nil, http.StatusNotFound, nil)
+}
+
+func TestMCTSAgent(t *testing.T) {
+	// The knight on a4 is free.
+	var free models.TestBoard
+	free[7][4], free[7][3] = 5, 11
+	free[0][4], free[4][0] = 4, 6
+	for _, guided := range []bool{true, false} {
+		if move := models.MCTSMove(free, 2000, guided); move[4][0] != 11 {
+			t.Error("expected the free knight taken", guided, move)
+		}
+	}
+
+	// Back rank mate.
+	var mate models.TestBoard
+	mate[7][6], mate[7][0], mate[6][5], mate[6][6], mate[6][7] = 5, 13, 9, 9, 9
+	mate[0][6], mate[1][5], mate[1][6], mate[1][7] = 4, 8, 8, 8
+	if move := models.MCTSMove(mate, 2000, true); move[0][0] != 13 {
+		t.Error("expected mate on the back rank", move)
+	}
+
+	start := time.Now()
+	if move := models.TimedMCTSMove(models.InitialBoard, 100); move == (models.TestBoard{}) {
+		t.Error("expected a move against the clock")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Error("mcts overran its budget", elapsed)
+	}
+
+	result, err := models.Match{White: "mcts-agent", Black: "base-agent", MoveLimit: 20}.Play()
+	if err != nil || result.Reason == "illegal move" {
+		t.Error("unexpected mcts agent result", result.Winner, result.Reason, err)
+	}
+	request(t, models.NewHandler(), http.MethodPost, "/agent", models.AgentCreateMessage{GameURL: "http://local/issue/game", Delegate: "mcts-agent", Iterations: -1}, http.StatusBadRequest, nil)
+}
+
+func TestWeightAgent(t *testing.T) {
+	// The knight on a4 is free, the pawn on d5 is defended from e6.
+	var free models.TestBoard
+	free[7][4], free[7][3] = 5, 11
+	free[0][4], free[4][0], free[3][3], free[2][4] = 4, 6, 8, 8
+	if move := models.WeightMove(free, true); move[4][0] != 11 {
+		t.Error("expected the free knight taken", move)
+	}
+	free[4][0] = 0
+	if move := models.WeightMove(free, true); move[3][3] == 11 {
+		t.Error("expected the defended pawn to be left", move)
+	}
+
+	// Back rank mate.
+	var mate models.TestBoard
+	mate[7][6], mate[7][0], mate[6][5], mate[6][6], mate[6][7] = 5, 13, 9, 9, 9
+	mate[0][6], mate[1][5], mate[1][6], mate[1][7] = 4, 8, 8, 8
+	for _, balance := range []bool{true, false} {
+		if move := models.WeightMove(mate, balance); move[0][0] != 13 {
+			t.Error("expected mate on the back rank", balance, move)
+		}
+	}
+
+	// Scores are repeatable and every root is scored once.
+	scores := models.WeightScores(models.InitialBoard)
+	if len(scores) != 20 || !reflect.DeepEqual(scores, models.WeightScores(models.InitialBoard)) {
+		t.Error("unexpected initial scores", scores)
+	}
+	if move := models.WeightMove(models.InitialBoard, true); move != models.WeightMove(models.InitialBoard, true) {
+		t.Error("expected the same move")
+	}
+
+	// Sequences listed by the game score as the agent scores them itself.
+	handler := models.NewHandler()
+	defer func(transport http.RoundTripper) { models.DefaultGameClient.Transport = transport }(models.DefaultGameClient.Transport)
+	models.DefaultGameClient.Transport = models.LocalTransport(handler)
+	var created models.BoardCreatedMessage
+	request(t, handler, http.MethodPost, "/issue", nil, http.StatusCreated, &created)
+	var states models.CursorMessage
+	request(t, handler, http.MethodGet, "/issue/"+created.ID.String()+"/states?lookahead=2", nil, http.StatusOK, &states)
+	if move := models.LookaheadMove(models.InitialBoard, 2, states.Sequences); move != models.LookaheadMove(models.InitialBoard, 2, nil) {
+		t.Error("expected the listed sequences played alike", move)
+	}
+	if move := models.LookaheadMove(free, 3, nil); move[3][3] == 11 {
+		t.Error("expected the defended pawn to be left three moves ahead", move)
+	}
+
+	// The fork on c7 wins the rook whatever the reply, though the worst leaf
+	// of taking the pawn on h4 is better than the worst leaf of the fork.
+	var fork models.TestBoard
+	fork[7][4], fork[3][1], fork[7][7] = 5, 7, 13
+	fork[0][4], fork[0][0], fork[4][7] = 4, 12, 8
+	if move := models.LookaheadMove(fork, 3, nil); move[1][2] != 7 {
+		t.Error("expected the fork played three moves ahead", move)
+	}
+
+	// Agents looking further ahead play from the sequences of the game.
+	gameURL := "http://local/issue/" + created.ID.String()
+	var agent models.AgentCreatedMessage
+	request(t, handler, http.MethodPost, "/agent", models.AgentCreateMessage{GameURL: gameURL, Delegate: "balance-agent", Lookahead: 3}, http.StatusCreated, &agent)
+	request(t, handler, http.MethodPost, "/agent", models.AgentCreateMessage{GameURL: gameURL, Delegate: "base-agent"}, http.StatusCreated, nil)
+	playAgent(t, handler, agent, nil, http.StatusOK, nil)
+	var board models.AbsoluteStateMessage
+	request(t, handler, http.MethodGet, "/issue/"+created.ID.String()+"/board", nil, http.StatusOK, &board)
+	if board.MoveCount == 0 {
+		t.Error("expected the agent to play")
+	}
+}
+
+func TestRejectedRounds(t *testing.T) {
+	handler := models.NewHandler()
+	defer func(transport http.RoundTripper) { models.DefaultGameClient.Transport = transport }(models.DefaultGameClient.Transport)
+	models.DefaultGameClient.Transport = models.LocalTransport(handler)
+	var rounds int32
+	models.RegisterEmptyAgent("empty-agent", &rounds)
+	defer models.UnregisterAgent("empty-agent")
+	var created models.BoardCreatedMessage
+	request(t, handler, http.MethodPost, "/issue", nil, http.StatusCreated, &created)
+	game := "/issue/" + created.ID.String()
+	gameURL := "http://local" + game
+	var agent models.AgentCreatedMessage
+	request(t, handler, http.MethodPost, "/agent", models.AgentCreateMessage{GameURL: gameURL, Delegate: "empty-agent"}, http.StatusCreated, &agent)
+	request(t, handler, http.MethodPost, "/agent", models.AgentCreateMessage{GameURL: gameURL, Delegate: "base-agent"}, http.StatusCreated, nil)
+
+	// A board the game keeps rejecting is returned after a few rounds.
+	var state models.BoardStateMessage
+	playAgent(t, handler, agent, nil, http.StatusOK, &state)
+	if !state.Invalid || state.End || atomic.LoadInt32(&rounds) != 3 {
+		t.Fatal("expected the rejection returned", state.Invalid, state.End, rounds)
+	}
+
+	// Checkmate ends the game, the mated agent has nothing to play.
+	for _, move := range [][2]string{{"f2", "f3"}, {"e7", "e5"}, {"g2", "g4"}, {"d8", "h4"}} {
+		request(t, handler, http.MethodPut, game+"/board", models.AbsoluteMoveMessage{From: move[0], To: move[1]}, http.StatusOK, nil)
+	}
+	playAgent(t, handler, agent, nil, http.StatusOK, &state)
+	if !state.End || state.Invalid || atomic.LoadInt32(&rounds) != 3 {
+		t.Error("expected the finished game returned", state.End, state.Invalid, rounds)
+	}
+}
+
+func TestStrategyAgents(t *testing.T) {
+	if mean := models.HarmonicPositive([]int{1, 4, 4}); mean != 2 {
+		t.Error("unexpected harmonic mean", mean)
+	}
+	if mean := models.HarmonicPositive([]int{3, -1}); mean != 0 {
+		t.Error("expected a non positive value to zero the mean", mean)
+	}
+	if min, max := models.MinValue([]int{3, -1, 2}), models.MaxValue([]int{3, -1, 2}); min != -1 || max != 3 {
+		t.Error("unexpected min and max", min, max)
+	}
+
+	// The knight on a4 is free, the pawn on d5 is defended from e6.
+	var free models.TestBoard
+	free[7][4], free[7][3] = 5, 11
+	free[0][4], free[4][0], free[3][3], free[2][4] = 4, 6, 8, 8
+	if move := models.AgentMove("new-agent", free); move[4][0] != 11 {
+		t.Error("expected the free knight taken", move)
+	}
+
+	// Back rank mate.
+	var mate models.TestBoard
+	mate[7][6], mate[7][0], mate[6][5], mate[6][6], mate[6][7] = 5, 13, 9, 9, 9
+	mate[0][6], mate[1][5], mate[1][6], mate[1][7] = 4, 8, 8, 8
+	for _, name := range []string{"balance-agent", "new-agent", "max-balance-agent", "max-positive-agent", "min-positive-agent"} {
+		if move := models.AgentMove(name, mate); move[0][0] != 13 {
+			t.Error("expected mate on the back rank", name, move)
+		}
+		result, err := models.Match{White: name, Black: "base-agent", MoveLimit: 20}.Play()
+		if err != nil || result.Reason == "illegal move" {
+			t.Error("unexpected strategy agent result", name, result.Winner, result.Reason, err)
+		}
+	}
+}
+
+func TestAgentSeed(t *testing.T) {
+	handler := models.NewHandler()
+	defer func(transport http.RoundTripper) { models.DefaultGameClient.Transport = transport }(models.DefaultGameClient.Transport)
+	models.DefaultGameClient.Transport = models.LocalTransport(handler)
+	var created models.BoardCreatedMessage
+	request(t, handler, http.MethodPost, "/issue", nil, http.StatusCreated, &created)
+	gameURL := "http://local/issue/" + created.ID.String()
+	var seeded, derived models.AgentCreatedMessage
+	request(t, handler, http.MethodPost, "/agent", models.AgentCreateMessage{User: true, GameURL: gameURL, Seed: 42}, http.StatusCreated, &seeded)
+	request(t, handler, http.MethodPost, "/agent", models.AgentCreateMessage{User: true, GameURL: gameURL}, http.StatusCreated, &derived)
+	if seed := models.AgentSeed(seeded.ID); seed != 42 {
+		t.Error("expected the given seed", seed)
+	}
+	if seed := models.AgentSeed(derived.ID); seed == 0 {
+		t.Error("expected a seed derived from the game")
+	}
+
+	// Identical seeds and positions play identically.
+	state := models.OpeningBoard(models.RandomOpening(6, rand.New(rand.NewSource(7))))
+	for _, name := range models.AgentNames() {
+		if name == "search-agent" {
+			continue
+		}
+		if models.SeededMove(name, state, 1) != models.SeededMove(name, state, 1) {
+			t.Error("expected the same move for the same seed", name)
+		}
+	}
+	varied := false
+	for seed := int64(1); seed <= 8 && !varied; seed++ {
+		varied = models.SeededMove("base-agent", state, seed) != models.SeededMove("base-agent", state, 0)
+	}
+	if !varied {
+		t.Error("expected seeds to vary the base agent")
+	}
+	// Each move of each side draws from a seed of its own.
+	seeds := map[int64]bool{}
+	for moveCount := 0; moveCount < 4; moveCount++ {
+		for _, isWhite := range []bool{true, false} {
+			seeds[models.MoveSeed(3, moveCount, isWhite)] = true
+		}
+	}
+	if len(seeds) != 8 {
+		t.Error("expected a seed for each move and side", seeds)
+	}
+	if workers := models.MCTSWorkers(false); workers != runtime.NumCPU() {
+		t.Error("expected parallel workers", workers)
+	}
+	if workers := models.MCTSWorkers(true); workers != 1 {
+		t.Error("expected one worker for deterministic play", workers)
+	}
+	match := models.Match{White: "base-agent", Black: "balance-agent", MoveLimit: 20, Seed: 3}
+	first, err := match.Play()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := match.Play()
+	if err != nil || !reflect.DeepEqual(first.Moves, second.Moves) {
+		t.Error("expected the same match for the same seed", first.Moves, second.Moves, err)
+	}
+}
+
+func TestEvaluationProfiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := models.WriteProfile(filepath.Join(dir, "profile-min.yaml"), models.WeightProfile("", "min", true)); err != nil {
+		t.Fatal(err)
+	}
+	if err := models.WriteProfile(filepath.Join(dir, "profile-max.json"), models.WeightProfile("profile-max-positive", "max", false)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a profile"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	defer models.UnregisterAgent("profile-min")
+	defer models.UnregisterAgent("profile-max-positive")
+	if err := models.LoadProfiles(dir); err != nil {
+		t.Fatal(err)
+	}
+	names := strings.Join(models.AgentNames(), ",")
+	if !strings.Contains(names, "profile-min") || !strings.Contains(names, "profile-max-positive") {
+		t.Fatal("expected the profiles registered", names)
+	}
+	if err := models.LoadProfiles(dir); err == nil {
+		t.Error("expected profiles registered twice to fail")
+	}
+
+	// Profiles of the built in weights play as the built in agents.
+	for i := int64(0); i < 4; i++ {
+		state := models.OpeningBoard(models.RandomOpening(6, rand.New(rand.NewSource(i))))
+		if models.SeededMove("profile-min", state, i) != models.SeededMove("new-agent", state, i) {
+			t.Error("expected the min profile to play as the new agent", i)
+		}
+		if models.SeededMove("profile-max-positive", state, i) != models.SeededMove("max-positive-agent", state, i) {
+			t.Error("expected the max profile to play as the max positive agent", i)
+		}
+	}
+
+	handler := models.NewHandler()
+	defer func(transport http.RoundTripper) { models.DefaultGameClient.Transport = transport }(models.DefaultGameClient.Transport)
+	models.DefaultGameClient.Transport = models.LocalTransport(handler)
+	var created models.BoardCreatedMessage
+	request(t, handler, http.MethodPost, "/issue", nil, http.StatusCreated, &created)
+	gameURL := "http://local/issue/" + created.ID.String()
+	request(t, handler, http.MethodPost, "/agent", models.AgentCreateMessage{GameURL: gameURL, Delegate: "profile-min"}, http.StatusCreated, nil)
+	request(t, handler, http.MethodPost, "/agent", models.AgentCreateMessage{GameURL: gameURL, Delegate: "no-such-profile"}, http.StatusBadRequest, nil)
+
+	// Invalid profiles are rejected.
+	for name, edit := range map[string]func(*models.EvaluationProfile){
+		"short table":   func(profile *models.EvaluationProfile) { profile.Squares["OwnPAWN"] = profile.Squares["OwnPAWN"][:7] },
+		"short row":     func(profile *models.EvaluationProfile) { profile.Squares["ZERO"][3] = profile.Squares["ZERO"][3][:5] },
+		"missing table": func(profile *models.EvaluationProfile) { delete(profile.Squares, "OppKING") },
+		"missing value": func(profile *models.EvaluationProfile) { delete(profile.Values, "OwnQUEEN") },
+		"unknown table": func(profile *models.EvaluationProfile) { profile.Squares["OwnDRAGON"] = profile.Squares["ZERO"] },
+		"unknown agent": func(profile *models.EvaluationProfile) { profile.Agent = "oracle" },
+		"no name":       func(profile *models.EvaluationProfile) { profile.Name = "" },
+	} {
+		profile := models.WeightProfile("invalid-"+strings.ReplaceAll(name, " ", "-"), "", true)
+		edit(&profile)
+		if err := models.RegisterProfile(profile); err == nil {
+			t.Error("expected the profile rejected", name)
+		}
+	}
+	if err := models.RegisterProfile(models.WeightProfile("search-agent", "search", true)); err == nil {
+		t.Error("expected a built in delegate name rejected")
+	}
+
+	// Search and mcts agents may evaluate with the tapered evaluator.
+	state := models.OpeningBoard(models.RandomOpening(6, rand.New(rand.NewSource(7))))
+	tapered := models.TaperedEvaluate(state)
+	for _, kind := range []string{"search", "mcts"} {
+		profile := models.WeightProfile("profile-tapered-"+kind, kind, true)
+		profile.Evaluator = "tapered"
+		if err := models.RegisterProfile(profile); err != nil {
+			t.Fatal(err)
+		}
+		defer models.UnregisterAgent(profile.Name)
+		if score := models.DelegateEvaluate(profile.Name, "", state); score != tapered {
+			t.Error("expected the tapered evaluation of the profile", kind, score, tapered)
+		}
+	}
+	for _, name := range []string{"search-agent", "mcts-agent"} {
+		if score := models.DelegateEvaluate(name, "tapered", state); score != tapered {
+			t.Error("expected the tapered evaluation of the agent", name, score, tapered)
+		}
+	}
+	if models.DelegateEvaluate("tapered-search-agent", "piece-square", state) == tapered {
+		t.Error("expected the piece-square evaluation of the agent")
+	}
+	for name, evaluator := range map[string]string{"weight": "tapered", "search": "oracle"} {
+		profile := models.WeightProfile("invalid-evaluator-"+name, name, true)
+		profile.Evaluator = evaluator
+		if err := models.RegisterProfile(profile); err == nil {
+			t.Error("expected the evaluator rejected", name, evaluator)
+		}
+	}
+	request(t, handler, http.MethodPost, "/agent", models.AgentCreateMessage{GameURL: gameURL, Delegate: "mcts-agent", Evaluator: "tapered"}, http.StatusCreated, nil)
+	request(t, handler, http.MethodPost, "/agent", models.AgentCreateMessage{GameURL: gameURL, Delegate: "profile-min", Evaluator: "tapered"}, http.StatusBadRequest, nil)
+	request(t, handler, http.MethodPost, "/agent", models.AgentCreateMessage{GameURL: gameURL, Delegate: "search-agent", Evaluator: "oracle"}, http.StatusBadRequest, nil)
+
+	path := filepath.Join(dir, "unknown.json")
+	if err := os.WriteFile(path, []byte(`{"Name": "unknown", "Colour": "white"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := models.ReadProfile(path); err == nil {
+		t.Error("expected unknown fields rejected")
+	}
+}
+
+func TestTaperedEvaluation(t *testing.T) {
+	if score := models.TaperedEvaluate(models.InitialBoard); score != 0 {
+		t.Error("expected an even initial position", score)
+	}
+
+	// Kings shelter in the midgame and walk to the centre in the endgame.
+	central := models.InitialBoard
+	central[7][4], central[4][4] = 0, 5
+	if models.TaperedEvaluate(central) >= models.TaperedEvaluate(models.InitialBoard) {
+		t.Error("expected the king safer at home in the midgame")
+	}
+	var corner, centre models.TestBoard
+	corner[7][0], corner[6][4], corner[0][4] = 5, 9, 4
+	centre[4][3], centre[6][4], centre[0][4] = 5, 9, 4
+	if models.TaperedEvaluate(centre) <= models.TaperedEvaluate(corner) {
+		t.Error("expected the king active in the endgame")
+	}
+
+	// Passed, doubled and isolated pawns.
+	var blocked, passed models.TestBoard
+	blocked[7][4], blocked[0][4], blocked[3][0], blocked[1][1] = 5, 4, 9, 8
+	passed[7][4], passed[0][4], passed[3][0], passed[1][3] = 5, 4, 9, 8
+	if models.TaperedEvaluate(passed) <= models.TaperedEvaluate(blocked) {
+		t.Error("expected a passed pawn bonus")
+	}
+	var doubled, connected models.TestBoard
+	doubled[7][0], doubled[0][7], doubled[6][3], doubled[5][3] = 5, 4, 9, 9
+	connected[7][0], connected[0][7], connected[6][3], connected[5][4] = 5, 4, 9, 9
+	if models.TaperedEvaluate(doubled) >= models.TaperedEvaluate(connected) {
+		t.Error("expected doubled isolated pawns to be weak")
+	}
+
+	// Rooks belong on open files.
+	var closed, open models.TestBoard
+	closed[7][4], closed[0][4], closed[7][0], closed[6][0] = 5, 4, 13, 9
+	open[7][4], open[0][4], open[7][0], open[6][7] = 5, 4, 13, 9
+	if models.TaperedEvaluate(open) <= models.TaperedEvaluate(closed) {
+		t.Error("expected a rook open file bonus")
+	}
+
+	// Bishop pair.
+	var pair, mixed models.TestBoard
+	pair[7][4], pair[0][4], pair[7][2], pair[7][5], pair[0][1], pair[0][6] = 5, 4, 3, 3, 6, 6
+	mixed[7][4], mixed[0][4], mixed[7][2], mixed[7][5], mixed[0][1], mixed[0][6] = 5, 4, 3, 3, 2, 2
+	if models.TaperedEvaluate(pair) <= 0 || models.TaperedEvaluate(mixed) > 0 {
+		t.Error("expected the bishop pair to count", models.TaperedEvaluate(pair), models.TaperedEvaluate(mixed))
+	}
+
+	var mate models.TestBoard
+	mate[7][6], mate[7][0], mate[6][5], mate[6][6], mate[6][7] = 5, 13, 9, 9, 9
+	mate[0][6], mate[1][5], mate[1][6], mate[1][7] = 4, 8, 8, 8
+	if move := models.AgentMove("tapered-search-agent", mate); move[0][0] != 13 {
+		t.Error("expected mate on the back rank", move)
+	}
+	result, err := models.Match{White: "tapered-search-agent", Black: "base-agent", MoveLimit: 30}.Play()
+	if err != nil || result.Winner == "black" || result.Reason == "illegal move" {
+		t.Error("unexpected tapered search result", result.Winner, result.Reason, err)
+	}
+}
+
+func TestTuning(t *testing.T) {
+	epd := `# material decides
+rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - c9 "1/2-1/2";
+rnb1kbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - c9 "1-0";
+rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNB1KBNR b KQkq - [0.0]
+1nbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR b KQk - c9 "1-0";
+rnbqkbn1/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQq - c9 "1-0";
+rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKB1R w KQkq - c9 "0-1";
+rnbqkbnr/pppppppp/8/8/8/8/PPPPPPP1/RNBQKBNR b KQkq - [0.5]
+
+rnbqkbnr/ppp1pppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - c9 "1-0";
+`
+	positions, err := models.ReadEPD(strings.NewReader(epd))
+	if err != nil || len(positions) != 8 {
+		t.Fatal("unexpected positions", len(positions), err)
+	}
+	if positions[0].State() != models.InitialBoard || positions[0].Result() != 0.5 {
+		t.Error("expected the initial position drawn", positions[0].State(), positions[0].Result())
+	}
+	// Results are for the side to move.
+	if positions[2].Result() != 1 || positions[3].Result() != 0 {
+		t.Error("expected results for the active player", positions[2].Result(), positions[3].Result())
+	}
+	if positions[2].State()[7][4] != 11 {
+		t.Error("expected the black queen active", positions[2].State())
+	}
+	for _, invalid := range []string{
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP w KQkq - c9 \"1-0\";",
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBN w KQkq - c9 \"1-0\";",
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNX w KQkq - c9 \"1-0\";",
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR x KQkq - c9 \"1-0\";",
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBN1 w KQkq - c9 \"1-0\";",
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - c9 \"*\";",
+	} {
+		if _, err := models.ReadEPD(strings.NewReader(invalid)); err == nil {
+			t.Error("expected an invalid EPD line rejected", invalid)
+		}
+	}
+
+	profile, err := models.BuiltinProfile("balance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var passes []float64
+	tuned, result, err := models.TuneProfile(profile, positions, models.TuningOptions{
+		Passes:   3,
+		Step:     50,
+		Progress: func(pass int, err float64) { passes = append(passes, err) },
+	})
+	if err != nil || result.Passes != 3 || len(passes) != 3 || result.K <= 0 {
+		t.Fatal("unexpected tuning", result, passes, err)
+	}
+	if !(result.FinalError < result.InitialError) || passes[2] != result.FinalError {
+		t.Error("expected the error to fall", result)
+	}
+	tuned.Name = "tuned-balance"
+	defer models.UnregisterAgent(tuned.Name)
+	if err := models.RegisterProfile(tuned); err != nil {
+		t.Error("expected a valid tuned profile", err)
+	}
+	if _, _, err := models.TuneProfile(profile, nil, models.TuningOptions{}); err == nil {
+		t.Error("expected tuning without positions to fail")
+	}
+	if _, err := models.BuiltinProfile("unknown"); err == nil {
+		t.Error("expected an unknown built in profile to fail")
+	}
+
+	// A finished decisive game labels each position for the side to move,
+	// the game ends with the checkmate.
+	handler := models.NewHandler()
+	var created models.BoardCreatedMessage
+	request(t, handler, http.MethodPost, "/issue", nil, http.StatusCreated, &created)
+	game := "/issue/" + created.ID.String()
+	var states []models.TestBoard
+	moves := []models.AbsoluteMoveMessage{{From: "f2", To: "f3"}, {From: "e7", To: "e5"}, {From: "g2", To: "g4"}, {From: "d8", To: "h4"}}
+	for i, move := range moves {
+		var board models.AbsoluteStateMessage
+		request(t, handler, http.MethodPut, game+"/board", move, http.StatusOK, &board)
+		if board.Invalid || board.End != (i == len(moves)-1) {
+			t.Fatal("unexpected move result", move, board.End, board.Invalid)
+		}
+		var state models.BoardStateMessage
+		request(t, handler, http.MethodGet, game, nil, http.StatusOK, &state)
+		states = append(states, state.State)
+	}
+	history := models.HistoryPositions(0)
+	for i, state := range states {
+		// Black wins, black is to move after the odd moves.
+		want := 0.0
+		if i%2 == 0 {
+			want = 1
+		}
+		found := false
+		for _, position := range history {
+			found = found || position.State() == state && position.Result() == want
+		}
+		if !found {
+			t.Error("expected the position labelled", i+1, want)
+		}
+	}
+	for _, position := range models.HistoryPositions(len(states) - 1) {
+		if position.State() == states[0] {
+			t.Error("expected the opening moves skipped")
+		}
+	}
+}
+
+// Polyglot move of absolute squares.
+func polyglotMove(from string, to string) uint16 {
+	square := func(name string) uint16 { return uint16(name[1]-'1')<<3 | uint16(name[0]-'a') }
+	return square(from)<<6 | square(to)
+}
+
+func TestOpeningBook(t *testing.T) {
+	defer models.UnloadBooks()
+	dir := t.TempDir()
+	keysPath := filepath.Join(dir, "keys.txt")
+	writeKeys := func(keys []uint64) {
+		var text strings.Builder
+		text.WriteString("Random64[781] = {\n")
+		for _, key := range keys {
+			text.WriteString("   U64(0x" + strconv.FormatUint(key, 16) + "),\n")
+		}
+		text.WriteString("};\n")
+		if err := os.WriteFile(keysPath, []byte(text.String()), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rng := rand.New(rand.NewSource(1))
+	keys := make([]uint64, 781)
+	for i := range keys {
+		keys[i] = rng.Uint64()
+	}
+	writeKeys(keys[:780])
+	if err := models.LoadPolyglotKeys(keysPath); err == nil {
+		t.Error("expected a short key table rejected")
+	}
+	writeKeys(keys)
+	if err := models.LoadPolyglotKeys(keysPath); err == nil {
+		t.Error("expected a key table with the wrong initial hash rejected")
+	}
+	// Fix the side to move key so the initial position hashes as published.
+	keys[780] ^= models.PolyglotHashKeys(keys, models.InitialBoard, true) ^ 0x463B96181691FC9C
+	writeKeys(keys)
+	if err := models.RegisterBook("early", models.OpeningBook{}); err == nil {
+		t.Error("expected books rejected before the keys load")
+	}
+	if err := models.LoadPolyglotKeys(keysPath); err != nil {
+		t.Fatal(err)
+	}
+
+	// Moves toggle the piece square and side keys, castling rights go with
+	// the king.
+	initial := models.PolyglotHash(models.InitialBoard, true)
+	e4 := models.OpeningBoard([]models.AbsoluteMoveMessage{{From: "e2", To: "e4"}})
+	if hash := models.PolyglotHash(e4, false); hash != initial^keys[64+8+4]^keys[64+24+4]^keys[780] {
+		t.Errorf("unexpected hash after e4 %016x", hash)
+	}
+	moved := models.InitialBoard
+	moved[7][4] &^= 0x10
+	if hash := models.PolyglotHash(moved, true); hash != initial^keys[768]^keys[769] {
+		t.Errorf("expected white castling rights lost %016x", hash)
+	}
+
+	// Sorted entries of the initial position and the reply to e4, castling
+	// and zero weight moves are never played.
+	type entry struct {
+		key          uint64
+		move, weight uint16
+	}
+	entries := []entry{
+		{initial, polyglotMove("e2", "e4"), 3},
+		{initial, polyglotMove("d2", "d4"), 1},
+		{initial, polyglotMove("g1", "f3"), 0},
+		{initial, polyglotMove("e1", "h1"), 50},
+		{models.PolyglotHash(e4, false), polyglotMove("e7", "e5"), 1},
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+	var data []byte
+	for _, e := range entries {
+		var raw [16]byte
+		binary.BigEndian.PutUint64(raw[0:], e.key)
+		binary.BigEndian.PutUint16(raw[8:], e.move)
+		binary.BigEndian.PutUint16(raw[10:], e.weight)
+		data = append(data, raw[:]...)
+	}
+	books := filepath.Join(dir, "books")
+	if err := os.Mkdir(books, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(books, "small.bin"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(books, "notes.txt"), []byte("not a book"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := models.LoadBooks(books); err != nil {
+		t.Fatal(err)
+	}
+	if err := models.LoadBooks(books); err == nil {
+		t.Error("expected a book registered twice to fail")
+	}
+	truncated := filepath.Join(dir, "truncated.bin")
+	if err := os.WriteFile(truncated, data[:20], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := models.ReadBook(truncated); err == nil {
+		t.Error("expected a truncated book rejected")
+	}
+	unsorted := filepath.Join(dir, "unsorted.bin")
+	if err := os.WriteFile(unsorted, append(append([]byte(nil), data[64:]...), data[:64]...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := models.ReadBook(unsorted); err == nil {
+		t.Error("expected an unsorted book rejected")
+	}
+
+	// Book moves by weight, then the agent.
+	toE4, toD4 := models.InitialBoard, models.InitialBoard
+	toE4[4][4], toE4[6][4] = toE4[6][4], 0
+	toD4[4][3], toD4[6][3] = toD4[6][3], 0
+	counts := map[models.TestBoard]int{}
+	for seed := int64(0); seed < 200; seed++ {
+		counts[models.BookMove("small", "base-agent", models.InitialBoard, true, seed)]++
+	}
+	if len(counts) != 2 || counts[toE4] <= counts[toD4] || counts[toD4] == 0 {
+		t.Error("expected e4 and d4 by weight", counts[toE4], counts[toD4], len(counts))
+	}
+	toE5 := e4
+	toE5[4][3], toE5[6][3] = toE5[6][3], 0
+	if models.BookMove("small", "new-agent", e4, false, 1) != toE5 {
+		t.Error("expected the book reply to e4")
+	}
+	a3 := models.OpeningBoard([]models.AbsoluteMoveMessage{{From: "a2", To: "a3"}})
+	for seed := int64(0); seed < 4; seed++ {
+		if models.BookMove("small", "base-agent", a3, false, seed) != models.SeededMove("base-agent", a3, seed) {
+			t.Error("expected the agent to play out of book", seed)
+		}
+	}
+
+	result, err := models.Match{White: "base-agent", Black: "base-agent", MoveLimit: 2, WhiteBook: "small", BlackBook: "small"}.Play()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first := result.Moves[0]; first.To != "e4" && first.To != "d4" {
+		t.Error("expected a book opening", first)
+	} else if first.To == "e4" && result.Moves[1] != (models.AbsoluteMoveMessage{From: "e7", To: "e5"}) {
+		t.Error("expected the book reply", result.Moves[1])
+	}
+	if _, err := (models.Match{White: "base-agent", Black: "base-agent", WhiteBook: "missing"}).Play(); err == nil {
+		t.Error("expected an unknown book to fail")
+	}
+
+	handler := models.NewHandler()
+	defer func(transport http.RoundTripper) { models.DefaultGameClient.Transport = transport }(models.DefaultGameClient.Transport)
+	models.DefaultGameClient.Transport = models.LocalTransport(handler)
+	var created models.BoardCreatedMessage
+	request(t, handler, http.MethodPost, "/issue", nil, http.StatusCreated, &created)
+	gameURL := "http://local/issue/" + created.ID.String()
+	request(t, handler, http.MethodPost, "/agent", models.AgentCreateMessage{GameURL: gameURL, Delegate: "base-agent", Book: "missing"}, http.StatusBadRequest, nil)
+	var agent models.AgentCreatedMessage
+	request(t, handler, http.MethodPost, "/agent", models.AgentCreateMessage{GameURL: gameURL, Delegate: "base-agent", Book: "small"}, http.StatusCreated, &agent)
+	request(t, handler, http.MethodPost, "/agent", models.AgentCreateMessage{GameURL: gameURL, Delegate: "base-agent"}, http.StatusCreated, nil)
+	playAgent(t, handler, agent, nil, http.StatusOK, nil)
+	var board models.AbsoluteStateMessage
+	request(t, handler, http.MethodGet, "/issue/"+created.ID.String()+"/board", nil, http.StatusOK, &board)
+	if board.MoveCount == 0 {
+		t.Fatal("expected the agent to play")
+	}
+	if _, ok := board.Pieces["e4"]; !ok {
+		if _, ok := board.Pieces["d4"]; !ok {
+			t.Error("expected a book opening", board.Pieces)
+		}
+	}
+}
+
+// Hashes of the Polyglot format description with the standard Random64
+// table named by CHESS_POLYGLOT_KEYS.
+//
+// Positions with an en passant capture are left out, boards do not record
+// them.
+func TestPolyglotHash(t *testing.T) {
+	path := os.Getenv("CHESS_POLYGLOT_KEYS")
+	if path == "" {
+		t.Skip("CHESS_POLYGLOT_KEYS not set")
+	}
+	defer models.UnloadBooks()
+	if err := models.LoadPolyglotKeys(path); err != nil {
+		t.Fatal(err)
+	}
+	moves := func(names ...string) []models.AbsoluteMoveMessage {
+		out := make([]models.AbsoluteMoveMessage, len(names))
+		for i, name := range names {
+			out[i] = models.AbsoluteMoveMessage{From: name[:2], To: name[2:]}
+		}
+		return out
+	}
+	for _, test := range []struct {
+		moves []models.AbsoluteMoveMessage
+		hash  uint64
+	}{
+		{nil, 0x463B96181691FC9C},
+		{moves("e2e4"), 0x823C9B50FD114196},
+		{moves("e2e4", "d7d5"), 0x0756B94461C50FB0},
+		{moves("e2e4", "d7d5", "e4e5"), 0x662FAFB965DB29D4},
+		// White castling rights go with the king.
+		{moves("e2e4", "d7d5", "e4e5", "f7f5", "e1e2"), 0x652A607CA3F242C1},
+		{moves("e2e4", "d7d5", "e4e5", "f7f5", "e1e2", "e8f7"), 0x00FDD303C946BDD9},
+	} {
+		activeWhite := len(test.moves)%2 == 0
+		if hash := models.PolyglotHash(models.OpeningBoard(test.moves), activeWhite); hash != test.hash {
+			t.Errorf("unexpected hash after %v %016x, want %016x", test.moves, hash, test.hash)
+		}
+	}
+}
+
+// Nodes searched on a fixed position set as ordering features are added.
+func BenchmarkSearchOrdering(b *testing.B) {
+	positions := []models.TestBoard{models.InitialBoard}
+	for seed := int64(1); seed <= 5; seed++ {
+		positions = append(positions, models.OpeningBoard(models.RandomOpening(12, rand.New(rand.NewSource(seed)))))
+	}
+	for _, config := range []struct {
+		name     string
+		features []string
+	}{
+		{"quiescence", []string{"quiescence"}},
+		{"mvv-lva", []string{"quiescence", "mvv-lva"}},
+		{"killers", []string{"quiescence", "mvv-lva", "killers"}},
+		{"history", []string{"quiescence", "mvv-lva", "killers", "history"}},
+	} {
+		b.Run(config.name, func(b *testing.B) {
+			nodes := 0
+			for i := 0; i < b.N; i++ {
+				for _, position := range positions {
+					nodes += models.SearchNodes(position, 3, config.features...)
+				}
+			}
+			b.ReportMetric(float64(nodes)/float64(b.N), "nodes/op")
+		})
+	}
+}
+
 // from collections import deque
 // from itertools import starmap
 // from pytest import raises
@@ -0,0 +1,199 @@
+package models
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Match termination reasons besides the game end reasons.
+const (
+	moveLimit   = "move limit"
+	illegalMove = "illegal move"
+)
+
+// Match between two registered agents played in process.
+//
+// Opening moves are played before the agents take over. MoveLimit counts
+// moves by either side including the opening, zero plays until the game
+// ends. Seed seeds the random choices of both agents, each move of each
+// side draws from a seed of its own. Deterministic runs the mcts agents on
+// one worker so the match repeats with the seed. WhiteBook and BlackBook
+// name the opening books the agents play from, if any.
+type Match struct {
+	White, Black         string
+	Opening              []AbsoluteMoveMessage
+	MoveLimit            int
+	Seed                 int64
+	Deterministic        bool
+	WhiteBook, BlackBook string
+}
+
+// MatchResult model.
+//
+// Winner is white, black or empty for a draw. MoveTimes holds the time each
+// agent took to choose each move.
+type MatchResult struct {
+	White, Black string
+	Winner       string
+	Reason       string
+	Moves        []AbsoluteMoveMessage
+	MoveTimes    []time.Duration
+	Duration     time.Duration
+	State        board
+}
+
+// Play the match to the end, the move limit or an illegal move.
+func (match Match) Play() (MatchResult, error) {
+	players := map[bool]baseAgent{}
+	for isWhite, name := range map[bool]string{true: match.White, false: match.Black} {
+		agent, ok := agents[name]
+		if !ok {
+			return MatchResult{}, fmt.Errorf("no agent named %q", name)
+		}
+		players[isWhite] = agent
+	}
+	configs := map[bool]agentModel{
+		true:  {Seed: match.Seed, Book: match.WhiteBook, Deterministic: match.Deterministic},
+		false: {Seed: match.Seed, Book: match.BlackBook, Deterministic: match.Deterministic},
+	}
+	for _, model := range configs {
+		if _, ok := books[model.Book]; model.Book != "" && !ok {
+			return MatchResult{}, fmt.Errorf("no book named %q", model.Book)
+		}
+	}
+	result := MatchResult{White: match.White, Black: match.Black}
+	game := boardModel{ID: newID(), State: initialBoard}
+	start := time.Now()
+	for _, move := range match.Opening {
+		next, ok := game.playOpening(move)
+		if !ok {
+			return MatchResult{}, fmt.Errorf("illegal opening move %s%s", move.From, move.To)
+		}
+		result.Moves = append(result.Moves, move)
+		result.MoveTimes = append(result.MoveTimes, 0)
+		game = next
+	}
+	for game.active() {
+		if match.MoveLimit > 0 && game.MoveCount >= match.MoveLimit {
+			result.Reason = moveLimit
+			break
+		}
+		legal := nextBoards(game.State)
+		if len(legal) == 0 {
+			result.Reason = noMovesReason(game.State)
+			if result.Reason == checkmate {
+				result.Winner = colourName(!game.activeWhite())
+			}
+			break
+		}
+		boards := make(chan board, len(legal))
+		for _, b := range legal {
+			boards <- b
+		}
+		close(boards)
+		player := players[game.activeWhite()]
+		model := configs[game.activeWhite()]
+		model.Seed = moveSeed(match.Seed, game.MoveCount, game.activeWhite())
+		if configurable, ok := player.(configurableAgent); ok {
+			player = configurable.configure(model)
+		}
+		player = model.bookLayer(player, game.State, game.activeWhite())
+		moveStart := time.Now()
+		choice := player.playRound(boards)
+		result.MoveTimes = append(result.MoveTimes, time.Since(moveStart))
+		next, ok := game.playLegal(legal, choice)
+		if !ok {
+			result.Reason = illegalMove
+			result.Winner = colourName(!game.activeWhite())
+			break
+		}
+		result.Moves = append(result.Moves, game.moveTo(choice))
+		game = next
+	}
+	if result.Reason == "" {
+		result.Reason = game.endReason()
+		if result.Reason == kingCaptured {
+			result.Winner = colourName(!game.activeWhite())
+		}
+	}
+	result.Duration = time.Since(start)
+	result.State = absoluteBoard(game.State, game.activeWhite())
+	return result, nil
+}
+
+// Apply an absolute move if it is legal.
+func (board boardModel) playOpening(move AbsoluteMoveMessage) (next boardModel, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, invalid := r.(InvalidMessage); !invalid {
+				panic(r)
+			}
+			ok = false
+		}
+	}()
+	return board.playLegal(nextBoards(board.State), board.absoluteMove(move))
+}
+
+// RandomOpening of uniformly chosen legal moves, shorter if the game ends.
+func RandomOpening(moves int, rng *rand.Rand) []AbsoluteMoveMessage {
+	opening := make([]AbsoluteMoveMessage, 0, moves)
+	game := boardModel{State: initialBoard}
+	for len(opening) < moves && game.active() {
+		legal := nextBoards(game.State)
+		if len(legal) == 0 {
+			break
+		}
+		choice := legal[rng.Intn(len(legal))]
+		opening = append(opening, game.moveTo(choice))
+		game = game.update(choice)
+	}
+	return opening
+}
+
+// Apply a choice from the legal boards, false for anything else.
+func (board boardModel) playLegal(legal []board, choice board) (next boardModel, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, invalid := r.(InvalidMove); !invalid {
+				panic(r)
+			}
+			ok = false
+		}
+	}()
+	for _, b := range legal {
+		if b == choice {
+			return board.update(choice), true
+		}
+	}
+	return board, false
+}
+
+// Absolute move from the current state to a board in the active player view.
+func (board boardModel) moveTo(state board) AbsoluteMoveMessage {
+	activeWhite := board.activeWhite()
+	prev := absoluteBoard(board.State, activeWhite)
+	next := absoluteBoard(state, activeWhite)
+	var move AbsoluteMoveMessage
+	var piece uint8
+	for posY, r := range prev {
+		for posX, p := range r {
+			if p == next[posY][posX] {
+				continue
+			}
+			if next[posY][posX] == 0 {
+				move.From = squareName(posX, posY)
+				piece = p
+			} else {
+				move.To = squareName(posX, posY)
+			}
+		}
+	}
+	if move.To != "" {
+		toX, toY := parseSquare(move.To)
+		if promoted := next[toY][toX]; promoted&0xE != piece&0xE {
+			move.Promotion = pieceNames[promoted&0xE/2]
+		}
+	}
+	return move
+}
@@ -0,0 +1,263 @@
+package models
+
+import (
+	"math"
+	"math/rand"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Monte Carlo tree search defaults.
+const (
+	defaultIterations   = 1000
+	defaultPlayoutDepth = 16
+	mctsExploration     = 1.4
+	// Playouts choose a random move instead of the best evaluated one this
+	// often.
+	playoutEpsilon = 0.25
+	// Evaluation difference worth a 73% expected score at the end of a
+	// playout.
+	playoutScale = 200
+)
+
+// Monte Carlo tree search with UCT selection.
+//
+// Workers share one tree, a virtual loss on the path of each running
+// iteration spreads them over different lines. Playouts are random or,
+// when guided, prefer the move the weightAgent evaluation likes best.
+//
+// A configured agent asked for deterministic play runs one worker so its
+// play under an iteration budget repeats with the seed.
+type mctsAgent struct {
+	eval         searchAgent
+	iterations   int
+	budget       time.Duration
+	workers      int
+	playoutDepth int
+	guided       bool
+	seed         int64
+}
+
+// Mcts agent with another evaluation.
+func (agent mctsAgent) withEvaluator(evaluator evaluator) mctsAgent {
+	agent.eval = agent.eval.withEvaluator(evaluator)
+	return agent
+}
+
+func newMCTSAgent(weights weightAgent, guided bool) mctsAgent {
+	return mctsAgent{
+		eval:         newSearchAgent(weights, 0),
+		iterations:   defaultIterations,
+		workers:      runtime.NumCPU(),
+		playoutDepth: defaultPlayoutDepth,
+		guided:       guided,
+	}
+}
+
+// Run for the agent iterations, or as long as its time budget, with the
+// agent evaluator if it names one.
+func (agent mctsAgent) configure(model agentModel) baseAgent {
+	agent.budget = model.moveBudget()
+	agent.seed = model.Seed
+	if evaluator, err := namedEvaluator(model.Evaluator, agent.eval.weightAgent); err == nil && evaluator != nil {
+		agent = agent.withEvaluator(evaluator)
+	}
+	if model.Iterations > 0 {
+		agent.iterations = model.Iterations
+	} else if agent.budget > 0 {
+		agent.iterations = 0
+	}
+	if model.Deterministic {
+		agent.workers = 1
+	}
+	return agent
+}
+
+// Tree node for a board from the active player view.
+//
+// wins is from the view of the player that moved into the node.
+type mctsNode struct {
+	sync.Mutex
+	state    board
+	children []*mctsNode
+	untried  []board
+	expanded bool
+	// Reward for the active player when the game is decided here, otherwise
+	// negative.
+	terminal float64
+	visits   float64
+	virtual  float64
+	wins     float64
+}
+
+func newMCTSNode(state board) *mctsNode {
+	node := &mctsNode{state: state, terminal: -1}
+	switch {
+	case !(boardModel{State: state}).contains(KING | 1):
+		node.terminal = 0
+	case lookaheadCheck(state):
+		node.terminal = 1
+	}
+	return node
+}
+
+// UCT value of a child, counting running iterations as losses.
+func (node *mctsNode) uct(parentVisits float64) float64 {
+	visits := node.visits + node.virtual
+	if visits == 0 {
+		return math.Inf(1)
+	}
+	return node.wins/visits + mctsExploration*math.Sqrt(math.Log(parentVisits)/visits)
+}
+
+// Reward in [0, 1] for the active player at the end of a playout.
+func (agent mctsAgent) playout(state board, rng *rand.Rand) float64 {
+	reward := 1.0
+	for ply := 0; ; ply++ {
+		if !(boardModel{State: state}).contains(KING | 1) {
+			return 1 - reward
+		}
+		if lookaheadCheck(state) {
+			return reward
+		}
+		if ply >= agent.playoutDepth {
+			break
+		}
+		children := lookaheadBoardsForBoard(state, false)
+		if len(children) == 0 {
+			return 0.5
+		}
+		next := children[rng.Intn(len(children))]
+		if agent.guided && rng.Float64() >= playoutEpsilon {
+			best := math.MaxInt64
+			for _, child := range children {
+				// Lowest value for the opponent is best for the mover.
+				if score := agent.eval.evaluate(child); score < best {
+					best, next = score, child
+				}
+			}
+		}
+		state = next
+		reward = 1 - reward
+	}
+	score := 1 / (1 + math.Exp(-float64(agent.eval.evaluate(state))/playoutScale))
+	if reward == 1 {
+		return score
+	}
+	return 1 - score
+}
+
+// Select, expand, play out and back up one line.
+func (agent mctsAgent) iterate(root *mctsNode, rng *rand.Rand) {
+	path := []*mctsNode{root}
+	node := root
+	var reward float64
+	for {
+		node.Lock()
+		node.virtual++
+		if node.terminal >= 0 {
+			reward = node.terminal
+			node.Unlock()
+			break
+		}
+		if !node.expanded {
+			node.untried = lookaheadBoardsForBoard(node.state, false)
+			rng.Shuffle(len(node.untried), func(i, j int) {
+				node.untried[i], node.untried[j] = node.untried[j], node.untried[i]
+			})
+			node.expanded = true
+		}
+		if len(node.untried) > 0 {
+			child := newMCTSNode(node.untried[len(node.untried)-1])
+			node.untried = node.untried[:len(node.untried)-1]
+			child.virtual++
+			node.children = append(node.children, child)
+			node.Unlock()
+			path = append(path, child)
+			if child.terminal >= 0 {
+				reward = child.terminal
+			} else {
+				reward = agent.playout(child.state, rng)
+			}
+			break
+		}
+		if len(node.children) == 0 {
+			reward = 0.5
+			node.Unlock()
+			break
+		}
+		best := node.children[0]
+		bestValue := math.Inf(-1)
+		parentVisits := node.visits + node.virtual
+		for _, child := range node.children {
+			child.Lock()
+			value := child.uct(parentVisits)
+			child.Unlock()
+			if value > bestValue {
+				best, bestValue = child, value
+			}
+		}
+		node.Unlock()
+		node = best
+		path = append(path, node)
+	}
+	// reward is for the active player of the last node, its mover scores
+	// the opposite.
+	for i := len(path) - 1; i >= 0; i-- {
+		node := path[i]
+		node.Lock()
+		node.virtual--
+		node.visits++
+		node.wins += 1 - reward
+		node.Unlock()
+		reward = 1 - reward
+	}
+}
+
+// Play the most visited board.
+func (agent mctsAgent) playRound(boards <-chan board) board {
+	root := &mctsNode{terminal: -1, expanded: true}
+	for b := range boards {
+		root.children = append(root.children, newMCTSNode(swap(b)))
+	}
+	if len(root.children) == 0 {
+		return board{}
+	}
+	var deadline time.Time
+	if agent.budget > 0 {
+		deadline = time.Now().Add(agent.budget)
+	}
+	var next sync.Mutex
+	iteration := 0
+	more := func() bool {
+		next.Lock()
+		defer next.Unlock()
+		if agent.iterations > 0 && iteration >= agent.iterations {
+			return false
+		}
+		if !deadline.IsZero() && iteration > 0 && time.Now().After(deadline) {
+			return false
+		}
+		iteration++
+		return true
+	}
+	var workers sync.WaitGroup
+	for i := 0; i < agent.workers; i++ {
+		workers.Add(1)
+		go func(rng *rand.Rand) {
+			defer workers.Done()
+			for more() {
+				agent.iterate(root, rng)
+			}
+		}(rand.New(rand.NewSource(agent.seed + int64(i))))
+	}
+	workers.Wait()
+	best := root.children[0]
+	for _, child := range root.children {
+		if child.visits > best.visits {
+			best = child
+		}
+	}
+	return swap(best.state)
+}
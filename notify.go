@@ -0,0 +1,214 @@
+package models
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// SignatureHeader carries the hex HMAC-SHA256 of a notification body keyed
+// with the agent secret.
+const SignatureHeader = "X-Neuralknight-Signature"
+
+// Notification delivery settings.
+var (
+	notifyAttempts = 5
+	notifyBackoff  = 250 * time.Millisecond
+	notifyClient   = &http.Client{Timeout: 10 * time.Second}
+	notifications  sync.WaitGroup
+)
+
+// Notification delivery log.
+type deliveryModel struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	GameID    uuid.UUID `gorm:"index"`
+	Player    uuid.UUID
+	URL       string
+	Version   int
+	Attempt   int
+	Status    int
+	Error     string
+}
+
+// DeliveryMessage notify.
+type DeliveryMessage struct {
+	Player  uuid.UUID
+	URL     string
+	Version int
+	Attempt int
+	Status  int
+	Error   string
+	Time    time.Time
+}
+
+// DeliveriesMessage notify.
+type DeliveriesMessage struct {
+	Deliveries []DeliveryMessage
+}
+
+func newSecret() string {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Panicln(err)
+	}
+	return hex.EncodeToString(secret)
+}
+
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature checks a notification body against its signature header.
+func VerifySignature(secret string, body []byte, signature string) bool {
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// Secret of a registered agent, empty for agents from elsewhere.
+func agentSecret(ID uuid.UUID) string {
+	db := openDB()
+	defer closeDB(db)
+	var agent agentModel
+	if db.First(&agent, "id = ?", ID).RecordNotFound() {
+		return ""
+	}
+	return agent.Secret
+}
+
+func (board boardModel) playerURL(player uuid.UUID) string {
+	switch player {
+	case board.Player1:
+		return board.Player1URL
+	case board.Player2:
+		return board.Player2URL
+	}
+	return ""
+}
+
+// Inform player of game state.
+//
+// Delivery runs in the background with exponential backoff and every attempt
+// is logged, failures never affect the game.
+func (board boardModel) pokePlayer(player uuid.UUID) {
+	callback := board.playerURL(player)
+	if !validID(player) || callback == "" {
+		return
+	}
+	data, err := json.Marshal(board.stateMessage())
+	if err != nil {
+		log.Errorln(err)
+		return
+	}
+	notifications.Add(1)
+	go func() {
+		defer notifications.Done()
+		defer func() {
+			if r := recover(); r != nil {
+				log.Errorln("Notification failed", board.ID, player, r)
+			}
+		}()
+		secret := agentSecret(player)
+		backoff := notifyBackoff
+		for attempt := 1; attempt <= notifyAttempts; attempt++ {
+			status, err := deliver(callback, secret, data)
+			board.logDelivery(player, callback, attempt, status, err)
+			if err == nil {
+				return
+			}
+			if attempt < notifyAttempts {
+				time.Sleep(backoff)
+				backoff *= 2
+			}
+		}
+		log.Warnln("Gave up notifying", player, "of game", board.ID)
+	}()
+}
+
+// PUT a signed notification.
+func deliver(callback string, secret string, data []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPut, callback, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if secret != "" {
+		req.Header.Set(SignatureHeader, sign(secret, data))
+	}
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, deliveryFailed{resp.Status}
+	}
+	return resp.StatusCode, nil
+}
+
+type deliveryFailed struct {
+	status string
+}
+
+func (err deliveryFailed) Error() string {
+	return "Notification rejected with " + err.status + "."
+}
+
+func (board boardModel) logDelivery(player uuid.UUID, callback string, attempt int, status int, err error) {
+	delivery := deliveryModel{
+		GameID:  board.ID,
+		Player:  player,
+		URL:     callback,
+		Version: board.Version,
+		Attempt: attempt,
+		Status:  status,
+	}
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+	transaction(func(db *gorm.DB) {
+		if err := db.Create(&delivery).Error; err != nil {
+			log.Panicln(err)
+		}
+	})
+}
+
+// GetDeliveries game.
+func (board boardModel) GetDeliveries() DeliveriesMessage {
+	db := openDB()
+	defer closeDB(db)
+	var deliveries []deliveryModel
+	if err := db.Where("game_id = ?", board.ID).Order("id").Find(&deliveries).Error; err != nil {
+		log.Panicln(err)
+	}
+	out := make([]DeliveryMessage, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		out = append(out, DeliveryMessage{
+			Player:  delivery.Player,
+			URL:     delivery.URL,
+			Version: delivery.Version,
+			Attempt: delivery.Attempt,
+			Status:  delivery.Status,
+			Error:   delivery.Error,
+			Time:    delivery.CreatedAt,
+		})
+	}
+	return DeliveriesMessage{out}
+}
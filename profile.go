@@ -0,0 +1,271 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Agents an evaluation profile can drive.
+const (
+	weightProfile   = "weight"
+	searchProfile   = "search"
+	mctsProfile     = "mcts"
+	harmonicProfile = "harmonic"
+	minProfile      = "min"
+	maxProfile      = "max"
+)
+
+// EvaluationProfile of a weightAgent.
+//
+// Values holds the piece values and Squares the square tables by Own or Opp
+// and the piece, as in OwnPAWN, Squares also holds the ZERO table for empty
+// squares. Agent is one of weight, search, mcts, or the harmonic, min and
+// max strategies, weight by default. Evaluator names the evaluation of the
+// search and mcts agents, piece-square from the weights by default or
+// tapered.
+type EvaluationProfile struct {
+	Name      string             `yaml:"Name"`
+	Agent     string             `yaml:"Agent"`
+	Evaluator string             `yaml:"Evaluator,omitempty" json:",omitempty"`
+	Values    map[string]int     `yaml:"Values"`
+	Squares   map[string][][]int `yaml:"Squares"`
+}
+
+// Piece names of the profile keys.
+var profilePieces = []string{"PAWN", "KNIGHT", "BISHOP", "ROOK", "QUEEN", "KING"}
+
+// Table of empty squares.
+const zeroSquares = "ZERO"
+
+// Weight fields by profile key.
+func (agent *weightAgent) weightFields() (map[string]*int, map[string]*[8][8]int) {
+	values := map[string]*int{
+		"OwnPAWN":   &agent.OwnPAWNVal,
+		"OwnKNIGHT": &agent.OwnKNIGHTVal,
+		"OwnBISHOP": &agent.OwnBISHOPVal,
+		"OwnROOK":   &agent.OwnROOKVal,
+		"OwnQUEEN":  &agent.OwnQUEENVal,
+		"OwnKING":   &agent.OwnKINGVal,
+		"OppPAWN":   &agent.OppPAWNVal,
+		"OppKNIGHT": &agent.OppKNIGHTVal,
+		"OppBISHOP": &agent.OppBISHOPVal,
+		"OppROOK":   &agent.OppROOKVal,
+		"OppQUEEN":  &agent.OppQUEENVal,
+		"OppKING":   &agent.OppKINGVal,
+	}
+	squares := map[string]*[8][8]int{
+		"OwnPAWN":   &agent.OwnPAWNSquares,
+		"OwnKNIGHT": &agent.OwnKNIGHTSquares,
+		"OwnBISHOP": &agent.OwnBISHOPSquares,
+		"OwnROOK":   &agent.OwnROOKSquares,
+		"OwnQUEEN":  &agent.OwnQUEENSquares,
+		"OwnKING":   &agent.OwnKINGSquares,
+		"OppPAWN":   &agent.OppPAWNSquares,
+		"OppKNIGHT": &agent.OppKNIGHTSquares,
+		"OppBISHOP": &agent.OppBISHOPSquares,
+		"OppROOK":   &agent.OppROOKSquares,
+		"OppQUEEN":  &agent.OppQUEENSquares,
+		"OppKING":   &agent.OppKINGSquares,
+		zeroSquares: &agent.ZEROSquares,
+	}
+	return values, squares
+}
+
+// Profile of the agent weights.
+func (agent weightAgent) profile(name string, kind string) EvaluationProfile {
+	values, squares := agent.weightFields()
+	profile := EvaluationProfile{name, kind, "", make(map[string]int), make(map[string][][]int)}
+	for key, value := range values {
+		profile.Values[key] = *value
+	}
+	for key, table := range squares {
+		rows := make([][]int, 8)
+		for posY := range table {
+			rows[posY] = append([]int(nil), table[posY][:]...)
+		}
+		profile.Squares[key] = rows
+	}
+	return profile
+}
+
+// Profile keys in piece order, the ZERO table last.
+func profileKeys() []string {
+	keys := make([]string, 0, 13)
+	for _, side := range []string{"Own", "Opp"} {
+		for _, piece := range profilePieces {
+			keys = append(keys, side+piece)
+		}
+	}
+	return append(keys, zeroSquares)
+}
+
+// Validate the profile and build its weights.
+func (profile EvaluationProfile) weightAgent() (weightAgent, error) {
+	var agent weightAgent
+	values, squares := agent.weightFields()
+	for key, value := range profile.Values {
+		field, ok := values[key]
+		if !ok {
+			return agent, fmt.Errorf("profile %s: unknown piece value %s", profile.Name, key)
+		}
+		*field = value
+	}
+	for key, rows := range profile.Squares {
+		field, ok := squares[key]
+		if !ok {
+			return agent, fmt.Errorf("profile %s: unknown square table %s", profile.Name, key)
+		}
+		if len(rows) != 8 {
+			return agent, fmt.Errorf("profile %s: square table %s has %d rows, want 8", profile.Name, key, len(rows))
+		}
+		for posY, row := range rows {
+			if len(row) != 8 {
+				return agent, fmt.Errorf("profile %s: square table %s row %d has %d squares, want 8", profile.Name, key, posY, len(row))
+			}
+			copy(field[posY][:], row)
+		}
+	}
+	for _, key := range profileKeys() {
+		if _, ok := profile.Values[key]; !ok && key != zeroSquares {
+			return agent, fmt.Errorf("profile %s: missing piece value %s", profile.Name, key)
+		}
+		if _, ok := profile.Squares[key]; !ok {
+			return agent, fmt.Errorf("profile %s: missing square table %s", profile.Name, key)
+		}
+	}
+	return agent, nil
+}
+
+// Agent driven by the profile.
+func (profile EvaluationProfile) agent() (baseAgent, error) {
+	if profile.Name == "" {
+		return nil, errors.New("profile without a name")
+	}
+	weights, err := profile.weightAgent()
+	if err != nil {
+		return nil, err
+	}
+	evaluator, err := namedEvaluator(profile.Evaluator, weights)
+	if err != nil {
+		return nil, fmt.Errorf("profile %s: %w", profile.Name, err)
+	}
+	switch profile.Agent {
+	case searchProfile:
+		agent := newSearchAgent(weights, defaultSearchDepth)
+		if evaluator != nil {
+			agent = agent.withEvaluator(evaluator)
+		}
+		return agent, nil
+	case mctsProfile:
+		agent := newMCTSAgent(weights, true)
+		if evaluator != nil {
+			agent = agent.withEvaluator(evaluator)
+		}
+		return agent, nil
+	}
+	if evaluator != nil {
+		return nil, fmt.Errorf("profile %s: evaluator %s needs a search or mcts agent", profile.Name, profile.Evaluator)
+	}
+	switch profile.Agent {
+	case "", weightProfile:
+		return weights, nil
+	case harmonicProfile:
+		return newStrategyAgent(weights, harmonicPositive), nil
+	case minProfile:
+		return newStrategyAgent(weights, minValue), nil
+	case maxProfile:
+		return newStrategyAgent(weights, maxValue), nil
+	}
+	return nil, fmt.Errorf("profile %s: unknown agent %s", profile.Name, profile.Agent)
+}
+
+// ReadProfile from a JSON or YAML file, named after the file by default.
+func ReadProfile(path string) (EvaluationProfile, error) {
+	var profile EvaluationProfile
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return profile, err
+	}
+	switch ext := filepath.Ext(path); ext {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&profile)
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&profile)
+	default:
+		err = fmt.Errorf("unknown profile format %s", ext)
+	}
+	if err != nil {
+		return profile, fmt.Errorf("%s: %w", path, err)
+	}
+	if profile.Name == "" {
+		profile.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	return profile, nil
+}
+
+// WriteProfile as JSON or YAML by the file extension.
+func WriteProfile(path string, profile EvaluationProfile) error {
+	var data []byte
+	var err error
+	switch ext := filepath.Ext(path); ext {
+	case ".json":
+		data, err = json.MarshalIndent(profile, "", "  ")
+	case ".yaml", ".yml":
+		data, err = yaml.Marshal(profile)
+	default:
+		err = fmt.Errorf("unknown profile format %s", ext)
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// RegisterProfile as a delegate named after it.
+//
+// Profiles are registered at startup, before agents play.
+func RegisterProfile(profile EvaluationProfile) error {
+	if _, ok := agents[profile.Name]; ok || profile.Name == "user-agent" {
+		return fmt.Errorf("profile %s: delegate already registered", profile.Name)
+	}
+	agent, err := profile.agent()
+	if err != nil {
+		return err
+	}
+	agents[profile.Name] = agent
+	return nil
+}
+
+// LoadProfiles registers every JSON and YAML profile in a directory.
+func LoadProfiles(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		switch filepath.Ext(entry.Name()) {
+		case ".json", ".yaml", ".yml":
+		default:
+			continue
+		}
+		profile, err := ReadProfile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		if err := RegisterProfile(profile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
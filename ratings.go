@@ -0,0 +1,249 @@
+package models
+
+import (
+	"math"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// Rated subjects, each agent and each delegate type.
+const (
+	agentRating    = "agent"
+	delegateRating = "delegate"
+)
+
+// Elo settings.
+const (
+	initialRating = 1500
+	ratingK       = 32
+)
+
+// Current Elo rating of an agent or delegate.
+type ratingModel struct {
+	Kind      string `gorm:"primary_key"`
+	Name      string `gorm:"primary_key"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Rating    float64
+	Games     int
+	Wins      int
+	Draws     int
+	Losses    int
+}
+
+// Rating change from one finished game.
+type ratingHistoryModel struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	GameID    uuid.UUID `gorm:"index"`
+	Kind      string    `gorm:"index:idx_rating_history_subject"`
+	Name      string    `gorm:"index:idx_rating_history_subject"`
+	Opponent  string
+	Score     float64
+	Before    float64
+	After     float64
+}
+
+// RatingMessage ratings.
+type RatingMessage struct {
+	Kind, Name          string
+	Rating              float64
+	Games               int
+	Wins, Draws, Losses int
+}
+
+// LeaderboardMessage ratings.
+type LeaderboardMessage struct {
+	Ratings []RatingMessage
+}
+
+// RatingChangeMessage ratings.
+type RatingChangeMessage struct {
+	Time          time.Time
+	Game          uuid.UUID
+	Opponent      string
+	Score         float64
+	Before, After float64
+}
+
+// RatingHistoryMessage ratings.
+type RatingHistoryMessage struct {
+	Rating  RatingMessage
+	History []RatingChangeMessage
+}
+
+// Expected score against an opponent.
+func expectedScore(rating float64, opponent float64) float64 {
+	return 1 / (1 + math.Pow(10, (opponent-rating)/400))
+}
+
+// Score of the game for white, false while it is undecided.
+func (board boardModel) whiteScore() (float64, bool) {
+	switch board.endReason() {
+	case "":
+		return 0, false
+	case kingCaptured, checkmate:
+		// The player that just moved took or mated the king.
+		if board.activeWhite() {
+			return 0, true
+		}
+		return 1, true
+	}
+	return 0.5, true
+}
+
+// Load a rating, new subjects start at the initial rating.
+func loadRating(db *gorm.DB, kind string, name string) ratingModel {
+	rating := ratingModel{Kind: kind, Name: name, Rating: initialRating}
+	err := db.First(&rating, "kind = ? AND name = ?", kind, name).Error
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		log.Panicln(err)
+	}
+	return rating
+}
+
+// Apply a game result to a rating.
+func (rating *ratingModel) apply(db *gorm.DB, game uuid.UUID, opponent string, score float64, change float64) {
+	before := rating.Rating
+	rating.Rating += change
+	rating.Games++
+	switch score {
+	case 1:
+		rating.Wins++
+	case 0:
+		rating.Losses++
+	default:
+		rating.Draws++
+	}
+	if err := db.Save(rating).Error; err != nil {
+		log.Panicln(err)
+	}
+	history := ratingHistoryModel{
+		GameID:   game,
+		Kind:     rating.Kind,
+		Name:     rating.Name,
+		Opponent: opponent,
+		Score:    score,
+		Before:   before,
+		After:    rating.Rating,
+	}
+	if err := db.Create(&history).Error; err != nil {
+		log.Panicln(err)
+	}
+}
+
+// Update both ratings of a pairing with the score for white.
+func ratePair(db *gorm.DB, game uuid.UUID, kind string, white string, black string, score float64) {
+	if white == black {
+		return
+	}
+	whiteRating := loadRating(db, kind, white)
+	blackRating := loadRating(db, kind, black)
+	change := ratingK * (score - expectedScore(whiteRating.Rating, blackRating.Rating))
+	whiteRating.apply(db, game, black, score, change)
+	blackRating.apply(db, game, white, 1-score, -change)
+}
+
+// Delegate of a player, empty when the player is not an agent.
+func playerDelegate(db *gorm.DB, player uuid.UUID) string {
+	var agent agentModel
+	err := db.Select("delegate").First(&agent, "id = ?", player).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return ""
+	}
+	if err != nil {
+		log.Panicln(err)
+	}
+	return agent.Delegate
+}
+
+// Rate the players of a finished game, run with the final save.
+func (board boardModel) rate(db *gorm.DB) {
+	score, ok := board.whiteScore()
+	if !ok || !validID(board.Player1) || !validID(board.Player2) {
+		return
+	}
+	ratePair(db, board.ID, agentRating, board.Player1.String(), board.Player2.String(), score)
+	white := playerDelegate(db, board.Player1)
+	black := playerDelegate(db, board.Player2)
+	if white != "" && black != "" {
+		ratePair(db, board.ID, delegateRating, white, black, score)
+	}
+}
+
+// Rating kind query parameter, agent by default.
+func ratingKind(kind string) string {
+	switch kind {
+	case "", agentRating:
+		return agentRating
+	case delegateRating:
+		return delegateRating
+	}
+	panic(InvalidMessage{"kind must be agent or delegate"})
+}
+
+func (rating ratingModel) message() RatingMessage {
+	return RatingMessage{rating.Kind, rating.Name, rating.Rating, rating.Games, rating.Wins, rating.Draws, rating.Losses}
+}
+
+// GetLeaderboard ratings.
+//
+// Lists the highest rated agents, or delegates with kind=delegate, up to
+// limit entries.
+func GetLeaderboard(values url.Values) LeaderboardMessage {
+	kind := ratingKind(values.Get("kind"))
+	limit := 100
+	if param := values.Get("limit"); param != "" {
+		var err error
+		if limit, err = strconv.Atoi(param); err != nil || limit < 1 {
+			panic(InvalidMessage{"limit must be a positive number"})
+		}
+	}
+	db := openDB()
+	defer closeDB(db)
+	var ratings []ratingModel
+	if err := db.Where("kind = ?", kind).Order("rating desc").Limit(limit).Find(&ratings).Error; err != nil {
+		log.Panicln(err)
+	}
+	out := LeaderboardMessage{make([]RatingMessage, 0, len(ratings))}
+	for _, rating := range ratings {
+		out.Ratings = append(out.Ratings, rating.message())
+	}
+	return out
+}
+
+// NoRating error.
+type NoRating struct{}
+
+func (err NoRating) Error() string {
+	return "No rating found."
+}
+
+// GetRatingHistory ratings.
+func GetRatingHistory(kind string, name string) RatingHistoryMessage {
+	kind = ratingKind(kind)
+	db := openDB()
+	defer closeDB(db)
+	var rating ratingModel
+	err := db.First(&rating, "kind = ? AND name = ?", kind, name).Error
+	if gorm.IsRecordNotFoundError(err) {
+		panic(NoRating{})
+	}
+	if err != nil {
+		log.Panicln(err)
+	}
+	var history []ratingHistoryModel
+	if err := db.Where("kind = ? AND name = ?", kind, name).Order("id").Find(&history).Error; err != nil {
+		log.Panicln(err)
+	}
+	out := RatingHistoryMessage{rating.message(), make([]RatingChangeMessage, 0, len(history))}
+	for _, change := range history {
+		out.History = append(out.History, RatingChangeMessage{change.CreatedAt, change.GameID, change.Opponent, change.Score, change.Before, change.After})
+	}
+	return out
+}
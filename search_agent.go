@@ -0,0 +1,319 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Scores beyond any evaluation, less the plies to the king capture.
+const mateScore = math.MaxInt32 / 2
+
+// Default search depth when the agent has no lookahead.
+const defaultSearchDepth = 3
+
+// Deepest iteration when searching against the clock.
+const maxSearchDepth = 64
+
+// Deepest search without a clock, deeper ones take too long to answer a
+// round.
+const maxFixedSearchDepth = 8
+
+// Negamax alpha-beta search over the weightAgent piece-square evaluation.
+//
+// The search plays king capture chess, a side without its king has lost.
+// Captures and promotions are searched past the depth until the position
+// is quiet.
+//
+// With a budget the search deepens one ply at a time until the budget runs
+// out, depth then only caps the iterations.
+type searchAgent struct {
+	weightAgent
+	depth     int
+	budget    time.Duration
+	table     *transpositionTable
+	features  searchFeatures
+	evaluator evaluator
+}
+
+// Static evaluation of a board for the active player.
+type evaluator interface {
+	evaluate(board) int
+}
+
+// Evaluations a search or mcts agent may be given by name.
+const (
+	pieceSquareEvaluation = "piece-square"
+	taperedEvaluation     = "tapered"
+)
+
+// Evaluator of a name, from the agent weights for the piece-square tables,
+// nil for the agent's own.
+func namedEvaluator(name string, weights weightAgent) (evaluator, error) {
+	switch name {
+	case "":
+		return nil, nil
+	case pieceSquareEvaluation:
+		return newSearchAgent(weights, 0).evaluator, nil
+	case taperedEvaluation:
+		return newTaperedEvaluator(), nil
+	}
+	return nil, fmt.Errorf("unknown evaluator %s", name)
+}
+
+// Delegates that may be given an evaluator.
+func evaluatingAgent(agent baseAgent) bool {
+	switch agent.(type) {
+	case searchAgent, mctsAgent:
+		return true
+	}
+	return false
+}
+
+// Value of each piece on each square for the active player, less the value
+// of the same piece for the opponent.
+type pieceSquareEvaluator struct {
+	values *[16][8][8]int
+}
+
+func (evaluator pieceSquareEvaluator) evaluate(b board) int {
+	score := 0
+	for posY, r := range b {
+		for posX, piece := range r {
+			score += evaluator.values[piece&0xF][posY][posX]
+		}
+	}
+	return score
+}
+
+func newSearchAgent(weights weightAgent, depth int) searchAgent {
+	valueMap := weights.valueMap()
+	var scores, values [16][8][8]int
+	for piece := range scores {
+		for posY := range scores[piece] {
+			for posX := range scores[piece][posY] {
+				scores[piece][posY][posX] = getScore(board{}, posY, posX, uint8(piece), valueMap)
+			}
+		}
+	}
+	for piece := range values {
+		opponent := piece
+		if piece&0xE != 0 {
+			opponent = piece ^ 1
+		}
+		for posY := range values[piece] {
+			for posX := range values[piece][posY] {
+				values[piece][posY][posX] = scores[piece][posY][posX] - scores[opponent][7-posY][7-posX]
+			}
+		}
+	}
+	return searchAgent{weights, depth, 0, newTable(), allSearchFeatures, pieceSquareEvaluator{&values}}
+}
+
+// Table of the delegate itself, for agents without an ID.
+func newTable() *transpositionTable {
+	return &transpositionTable{size: defaultTableSize}
+}
+
+// Search agent with another evaluation, and a table of its own.
+func (agent searchAgent) withEvaluator(evaluator evaluator) searchAgent {
+	agent.evaluator = evaluator
+	agent.table = newTable()
+	return agent
+}
+
+// Search as deep as the agent lookahead, or as long as its time budget,
+// with the agent evaluator if it names one.
+//
+// Agents with an ID search with their own table, others with the table of
+// the delegate.
+func (agent searchAgent) configure(model agentModel) baseAgent {
+	agent.budget = model.moveBudget()
+	if evaluator, err := namedEvaluator(model.Evaluator, agent.weightAgent); err == nil && evaluator != nil {
+		agent = agent.withEvaluator(evaluator)
+	}
+	if validID(model.ID) {
+		agent.table = tableForAgent(model.ID, model.HashSize)
+	}
+	limit := maxFixedSearchDepth
+	if agent.budget > 0 {
+		limit = maxSearchDepth
+	}
+	if model.Lookahead > 0 {
+		agent.depth = model.Lookahead
+	} else if agent.budget > 0 {
+		agent.depth = maxSearchDepth
+	}
+	if agent.depth > limit {
+		agent.depth = limit
+	}
+	return agent
+}
+
+// Static value of a board for the active player.
+func (agent searchAgent) evaluate(b board) int {
+	return agent.evaluator.evaluate(b)
+}
+
+// State of one move search.
+type search struct {
+	searchAgent
+	deadline time.Time
+	nodes    int
+	stopped  bool
+	killers  [maxSearchPly][2]uint16
+	history  [7][64]int
+}
+
+// Stop once the deadline passes, checked every few nodes.
+func (s *search) expired() bool {
+	if !s.stopped && !s.deadline.IsZero() && s.nodes&0x3FF == 0 {
+		s.stopped = time.Now().After(s.deadline)
+	}
+	return s.stopped
+}
+
+// Value of a board for the active player searched to depth.
+func (s *search) negamax(b board, depth int, alpha int, beta int, ply int) int {
+	s.nodes++
+	if s.expired() {
+		return 0
+	}
+	if !(boardModel{State: b}).contains(KING | 1) {
+		return -mateScore + ply
+	}
+	if lookaheadCheck(b) {
+		return mateScore - ply - 1
+	}
+	if depth <= 0 {
+		if s.features.quiescence {
+			return s.quiesce(b, alpha, beta, ply)
+		}
+		return s.evaluate(b)
+	}
+	key := hashBoard(b)
+	entry, found := s.table.probe(key)
+	if found && int(entry.depth) >= depth {
+		score := scoreFromTable(entry.score, ply)
+		switch {
+		case entry.bound == exactBound,
+			entry.bound == lowerBound && score >= beta,
+			entry.bound == upperBound && score <= alpha:
+			return score
+		}
+	}
+	moves := searchMoves(b, false)
+	if len(moves) == 0 {
+		return 0
+	}
+	s.order(moves, ply, entry.best)
+	alphaStart := alpha
+	best := -mateScore - 1
+	var bestKey uint64
+	for _, move := range moves {
+		score := -s.negamax(move.next, depth-1, -beta, -alpha, ply+1)
+		if score > best {
+			best = score
+			bestKey = hashBoard(move.next)
+		}
+		if score > alpha {
+			alpha = score
+		}
+		if alpha >= beta {
+			s.cutoff(move, depth, ply)
+			break
+		}
+	}
+	if s.stopped {
+		return best
+	}
+	bound := exactBound
+	if best <= alphaStart {
+		bound = upperBound
+	} else if best >= beta {
+		bound = lowerBound
+	}
+	s.table.store(tableEntry{key, bestKey, scoreToTable(best, ply), int8(depth), bound})
+	return best
+}
+
+// Value of a board searching only captures and promotions.
+//
+// The active player may stand pat on the static value.
+func (s *search) quiesce(b board, alpha int, beta int, ply int) int {
+	s.nodes++
+	if s.expired() {
+		return 0
+	}
+	if !(boardModel{State: b}).contains(KING | 1) {
+		return -mateScore + ply
+	}
+	if lookaheadCheck(b) {
+		return mateScore - ply - 1
+	}
+	stand := s.evaluate(b)
+	if stand >= beta {
+		return stand
+	}
+	if stand > alpha {
+		alpha = stand
+	}
+	moves := searchMoves(b, true)
+	s.order(moves, ply, 0)
+	for _, move := range moves {
+		score := -s.quiesce(move.next, -beta, -alpha, ply+1)
+		if score >= beta {
+			return score
+		}
+		if score > alpha {
+			alpha = score
+		}
+	}
+	return alpha
+}
+
+// Search the root boards to depth, false if the deadline cut it short.
+//
+// Boards are reordered with the best first for the next iteration.
+func (s *search) root(boards []board, depth int) (int, bool) {
+	alpha := -mateScore - 1
+	best := 0
+	for i, b := range boards {
+		score := -s.negamax(swap(b), depth-1, -mateScore-1, -alpha, 1)
+		if s.stopped {
+			return alpha, false
+		}
+		if score > alpha {
+			alpha = score
+			best = i
+		}
+	}
+	boards[0], boards[best] = boards[best], boards[0]
+	return alpha, true
+}
+
+// Play the board with the best searched value, the first one on ties.
+//
+// Against the clock the best board of the last completed depth is played.
+func (agent searchAgent) playRound(boards <-chan board) board {
+	roots := make([]board, 0, 64)
+	for b := range boards {
+		roots = append(roots, b)
+	}
+	if len(roots) == 0 {
+		return board{}
+	}
+	s := search{searchAgent: agent}
+	if agent.budget <= 0 {
+		s.root(roots, agent.depth)
+		return roots[0]
+	}
+	s.deadline = time.Now().Add(agent.budget)
+	for depth := 1; depth <= agent.depth; depth++ {
+		score, completed := s.root(roots, depth)
+		if !completed || score >= mateScore-depth {
+			break
+		}
+	}
+	return roots[0]
+}
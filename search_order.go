@@ -0,0 +1,120 @@
+package models
+
+import "sort"
+
+// Search features, all enabled for the registered agents.
+type searchFeatures struct {
+	quiescence bool
+	mvvLva     bool
+	killers    bool
+	history    bool
+}
+
+var allSearchFeatures = searchFeatures{true, true, true, true}
+
+// Deepest ply with killer moves, quiescence included.
+const maxSearchPly = 128
+
+// Move with the details used to order it.
+type searchMove struct {
+	next     board
+	from, to uint8
+	piece    uint8
+	captured uint8
+	promote  uint8
+	order    int
+}
+
+// Capture or promotion.
+func (move searchMove) tactical() bool {
+	return move.captured != 0 || move.promote != 0
+}
+
+// Killer move key.
+func (move searchMove) squares() uint16 {
+	return uint16(move.from)<<8 | uint16(move.to)
+}
+
+// Piece values by piece type for ordering captures.
+var orderValues = [7]int{0, 3, 100, 3, 1, 9, 5}
+
+// Ordering bands, higher first.
+const (
+	orderTable   = 1 << 30
+	orderCapture = 1 << 24
+	orderKiller  = 1 << 20
+)
+
+// Moves for the active player, the next boards from the next player view.
+func searchMoves(b board, tacticalOnly bool) []searchMove {
+	moves := make([]searchMove, 0, 40)
+	for _, p := range activePieces(b) {
+		from := uint8(p.posY)*8 + uint8(p.posX)
+		piece := p.piece & 0xE
+		visitBoardsForPiece(b, p.piece, p.posX, p.posY, func(move [2]int8, promote uint8, next board) {
+			toX, toY := p.posX+move[0], p.posY+move[1]
+			captured := b[toY][toX] & 0xE
+			if tacticalOnly && captured == 0 && promote == 0 {
+				return
+			}
+			moves = append(moves, searchMove{
+				next:     swap(next),
+				from:     from,
+				to:       uint8(toY)*8 + uint8(toX),
+				piece:    piece,
+				captured: captured,
+				promote:  promote,
+			})
+		})
+	}
+	return moves
+}
+
+// Most valuable victim first, least valuable attacker first among them.
+func mvvLva(move searchMove) int {
+	return orderValues[move.captured/2]*16 + orderValues[move.promote/2]*16 - orderValues[move.piece/2]
+}
+
+// Order moves with the stored best move, captures, killers and history.
+func (s *search) order(moves []searchMove, ply int, best uint64) {
+	found := best == 0
+	for i := range moves {
+		move := &moves[i]
+		switch {
+		case !found && hashBoard(move.next) == best:
+			move.order = orderTable
+			found = true
+		case move.tactical() && s.features.mvvLva:
+			move.order = orderCapture + mvvLva(*move)
+		case s.features.killers && ply < maxSearchPly && (s.killers[ply][0] == move.squares() || s.killers[ply][1] == move.squares()):
+			move.order = orderKiller
+		case s.features.history:
+			move.order = s.history[move.piece/2][move.to]
+		}
+	}
+	sort.SliceStable(moves, func(i, j int) bool {
+		return moves[i].order > moves[j].order
+	})
+}
+
+// Remember a quiet move that caused a cutoff.
+func (s *search) cutoff(move searchMove, depth int, ply int) {
+	if move.tactical() {
+		return
+	}
+	if s.features.killers && ply < maxSearchPly && s.killers[ply][0] != move.squares() {
+		s.killers[ply][1] = s.killers[ply][0]
+		s.killers[ply][0] = move.squares()
+	}
+	if s.features.history {
+		s.history[move.piece/2][move.to] += depth * depth
+		if s.history[move.piece/2][move.to] >= orderKiller {
+			// Age the table before history outranks killers.
+			for piece := range s.history {
+				for to := range s.history[piece] {
+					s.history[piece][to] /= 2
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	uuid "github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// InvalidMessage error.
+type InvalidMessage struct {
+	Reason string
+}
+
+func (err InvalidMessage) Error() string {
+	return err.Reason
+}
+
+// ErrorMessage server.
+type ErrorMessage struct {
+	Error string
+}
+
+// Decode a request message.
+func decodeMessage(decoder *json.Decoder, message interface{}) {
+	if err := decoder.Decode(message); err != nil {
+		panic(InvalidMessage{err.Error()})
+	}
+}
+
+// Map a recovered panic to a response status.
+func errorStatus(r interface{}) (int, string) {
+	switch err := r.(type) {
+	case NoBoard, NoAgent, NoRating:
+		return http.StatusNotFound, err.(error).Error()
+	case GameFull, StaleGame:
+		return http.StatusConflict, err.(error).Error()
+	case InvalidMessage, InvalidMove:
+		return http.StatusBadRequest, err.(error).Error()
+	case GameServerError:
+		return http.StatusBadGateway, err.Error()
+	case *log.Entry:
+		return http.StatusInternalServerError, err.Message
+	case error:
+		return http.StatusInternalServerError, err.Error()
+	}
+	return http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError)
+}
+
+func writeMessage(w http.ResponseWriter, status int, message interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(message); err != nil {
+		log.Errorln(err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, reason string) {
+	writeMessage(w, status, ErrorMessage{reason})
+}
+
+type handler struct{}
+
+// NewHandler serves the game and agent REST routes.
+//
+//	GET  /issue                   list games
+//	POST /issue                   create a game
+//	GET  /issue/events            Server-Sent Events of created games
+//	GET  /issue/{game}            current state
+//	POST /issue/{game}            join a game
+//	PUT  /issue/{game}            make a move
+//	GET  /issue/{game}/states     next board states from the mover view as played, ?lookahead=1..3&cursor=id
+//	GET  /issue/{game}/info       print the board, ?style=emoji|ascii|unicode&side=white|black
+//	GET  /issue/{game}/deliveries notification delivery log
+//	GET  /issue/{game}/board      absolute view with white at the bottom
+//	PUT  /issue/{game}/board      move in absolute coordinates
+//	GET  /issue/{game}/socket     WebSocket event stream, ?since=version&player=id&signature=hmac
+//	GET  /issue/{game}/events     Server-Sent Events of the game
+//	POST /agent                   create an agent
+//	GET  /agent/{id}              current state of the agent game
+//	PUT  /agent/{id}              play a round, signed with the agent secret
+//	GET  /agent/{id}/table        search transposition table statistics
+//	GET  /ratings                 leaderboard, ?kind=agent|delegate&limit=n
+//	GET  /ratings/{kind}/{name}   rating history of an agent or delegate
+func NewHandler() http.Handler {
+	return handler{}
+}
+
+func (handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			status, reason := errorStatus(rec)
+			if status == http.StatusInternalServerError {
+				log.Errorln(r.Method, r.URL.Path, reason)
+			}
+			writeError(w, status, reason)
+		}
+	}()
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	decoder := json.NewDecoder(r.Body)
+	switch {
+	case len(path) == 1 && path[0] == "issue":
+		switch r.Method {
+		case http.MethodGet:
+			writeMessage(w, http.StatusOK, GetGames(decoder))
+		case http.MethodPost:
+			writeMessage(w, http.StatusCreated, MakeGame(decoder))
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodPost)
+		}
+	case len(path) == 2 && path[0] == "issue" && path[1] == "events":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		serveGameFeed(w, r)
+	case len(path) >= 2 && len(path) <= 3 && path[0] == "issue":
+		ID, ok := pathID(w, path[1])
+		if !ok {
+			return
+		}
+		if len(path) == 3 {
+			serveGameResource(w, r, ID, path[2], decoder)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			writeMessage(w, http.StatusOK, GetGame(ID).GetState(r.URL.Query()))
+		case http.MethodPost:
+			writeMessage(w, http.StatusOK, GetGame(ID).AddPlayer(decoder))
+		case http.MethodPut:
+			writeMessage(w, http.StatusOK, GetGame(ID).PlayRound(decoder))
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodPost, http.MethodPut)
+		}
+	case len(path) == 1 && path[0] == "agent":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		writeMessage(w, http.StatusCreated, MakeAgent(r.Context(), decoder))
+	case len(path) == 2 && path[0] == "agent":
+		ID, ok := pathID(w, path[1])
+		if !ok {
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			writeMessage(w, http.StatusOK, GetAgent(ID).GetState(r.Context(), decoder))
+		case http.MethodPut:
+			agent := GetAgent(ID)
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				panic(InvalidMessage{err.Error()})
+			}
+			secret := agentSecret(ID)
+			if secret == "" || !VerifySignature(secret, body, r.Header.Get(SignatureHeader)) {
+				writeError(w, http.StatusUnauthorized, "Invalid signature.")
+				return
+			}
+			decoder = json.NewDecoder(bytes.NewReader(body))
+			writeMessage(w, http.StatusOK, agent.PlayRound(r.Context(), decoder))
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodPut)
+		}
+	case len(path) == 3 && path[0] == "agent" && path[2] == "table":
+		ID, ok := pathID(w, path[1])
+		if !ok {
+			return
+		}
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		writeMessage(w, http.StatusOK, GetAgent(ID).GetTableStats())
+	case len(path) == 1 && path[0] == "ratings":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		writeMessage(w, http.StatusOK, GetLeaderboard(r.URL.Query()))
+	case len(path) == 3 && path[0] == "ratings":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		writeMessage(w, http.StatusOK, GetRatingHistory(path[1], path[2]))
+	default:
+		writeError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+	}
+}
+
+func serveGameResource(w http.ResponseWriter, r *http.Request, ID uuid.UUID, resource string, decoder *json.Decoder) {
+	if resource == "socket" {
+		serveSocket(w, r, ID)
+		return
+	}
+	if resource == "events" {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		serveEvents(w, r, ID)
+		return
+	}
+	if resource == "board" {
+		switch r.Method {
+		case http.MethodGet:
+			writeMessage(w, http.StatusOK, GetGame(ID).GetBoard(r.URL.Query()))
+		case http.MethodPut:
+			writeMessage(w, http.StatusOK, GetGame(ID).PlayMove(decoder))
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodPut)
+		}
+		return
+	}
+	if resource != "states" && resource != "info" && resource != "deliveries" {
+		writeError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return
+	}
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	switch resource {
+	case "states":
+		writeMessage(w, http.StatusOK, GetGame(ID).GetStates(r.URL.Query()))
+	case "info":
+		writeMessage(w, http.StatusOK, GetGame(ID).GetInfo(r.URL.Query()))
+	case "deliveries":
+		writeMessage(w, http.StatusOK, GetGame(ID).GetDeliveries())
+	}
+}
+
+func pathID(w http.ResponseWriter, segment string) (uuid.UUID, bool) {
+	ID, err := uuid.FromString(segment)
+	if err != nil || !validID(ID) {
+		writeError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return ID, false
+	}
+	return ID, true
+}
+
+func methodNotAllowed(w http.ResponseWriter, methods ...string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+}
@@ -0,0 +1,196 @@
+package models
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/websocket"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// Socket message types.
+const (
+	moveMessage = "move"
+	drawMessage = "draw"
+)
+
+// SocketMessage socket.
+//
+// Type is move or draw. Moves give either the next State in the active
+// player view or From and To squares in absolute coordinates, a draw offer
+// made by both players ends the game. Only the player of the socket may move,
+// on their turn, or offer a draw, sockets without a player are read-only.
+type SocketMessage struct {
+	Type      string
+	State     *board
+	From, To  string
+	Promotion string
+}
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+// Optional version query parameter, def when missing.
+func versionParam(r *http.Request, name string, def int) int {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def
+	}
+	version, err := strconv.Atoi(value)
+	if err != nil {
+		panic(InvalidMessage{"Invalid " + name + "."})
+	}
+	return version
+}
+
+// Optional player query parameter.
+func playerParam(r *http.Request) uuid.UUID {
+	value := r.URL.Query().Get("player")
+	if value == "" {
+		return uuid.UUID{}
+	}
+	player, err := uuid.FromString(value)
+	if err != nil || !validID(player) {
+		panic(InvalidMessage{"Invalid player."})
+	}
+	return player
+}
+
+// Player of a socket, the zero UUID for a read-only socket, false when the
+// player is not proven.
+//
+// Player IDs are public in join events, so the player query parameter only
+// counts with the signature query parameter, the hex HMAC-SHA256 of the game
+// ID keyed by the secret of the player agent.
+func socketPlayer(r *http.Request, ID uuid.UUID) (uuid.UUID, bool) {
+	player := playerParam(r)
+	if !validID(player) {
+		return player, true
+	}
+	secret := agentSecret(player)
+	return player, secret != "" && VerifySignature(secret, []byte(ID.String()), r.URL.Query().Get("signature"))
+}
+
+// End the game in a draw.
+func (board *boardModel) agreeDraw(player uuid.UUID) {
+	if !board.active() {
+		return
+	}
+	next := *board
+	next.EndReason = drawAgreed
+	transaction(func(db *gorm.DB) {
+		next.save(db)
+		next.rate(db)
+	})
+	*board = next
+	events.publish(board.event(endEvent, player))
+}
+
+// Stream game events over a WebSocket.
+//
+// Events after the since query parameter are replayed from the move history,
+// followed by the current state and then live events.
+func serveSocket(w http.ResponseWriter, r *http.Request, ID uuid.UUID) {
+	player, ok := socketPlayer(r, ID)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "Invalid signature.")
+		return
+	}
+	sub := events.subscribe(ID)
+	defer events.unsubscribe(ID, sub)
+	game := GetGame(ID).(*boardModel)
+	since := versionParam(r, "since", game.Version)
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Warnln(err)
+		return
+	}
+	defer conn.Close()
+
+	replies := make(chan interface{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			var message SocketMessage
+			if err := conn.ReadJSON(&message); err != nil {
+				return
+			}
+			if reply := handleSocketMessage(ID, player, message); reply != nil {
+				select {
+				case replies <- reply:
+				case <-r.Context().Done():
+					return
+				}
+			}
+		}
+	}()
+
+	for _, event := range game.replay(since) {
+		if err := conn.WriteJSON(event); err != nil {
+			return
+		}
+	}
+	for {
+		select {
+		case event, ok := <-sub:
+			if !ok {
+				// Dropped for falling behind, the client replays on reconnect.
+				return
+			}
+			if event.ID <= game.Version && event.Type != drawEvent {
+				continue
+			}
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		case reply := <-replies:
+			if err := conn.WriteJSON(reply); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// Apply a socket message, returns a reply for the sender only.
+func handleSocketMessage(ID uuid.UUID, player uuid.UUID, message SocketMessage) (reply interface{}) {
+	defer func() {
+		if r := recover(); r != nil {
+			_, reason := errorStatus(r)
+			reply = ErrorMessage{reason}
+		}
+	}()
+	game := GetGame(ID).(*boardModel)
+	switch message.Type {
+	case moveMessage:
+		if !validID(player) || player != game.activePlayer() {
+			panic(InvalidMessage{"Moves need the active player."})
+		}
+		var state board
+		if message.State != nil {
+			state = *message.State
+		} else {
+			state = game.absoluteMove(AbsoluteMoveMessage{message.From, message.To, message.Promotion})
+		}
+		if result := game.play(state); result.Invalid {
+			return result
+		}
+	case drawMessage:
+		if !validID(player) || player != game.Player1 && player != game.Player2 {
+			panic(InvalidMessage{"Draw offers need a player of the game."})
+		}
+		if events.offerDraw(ID, player) {
+			game.agreeDraw(player)
+		} else {
+			events.publish(game.event(drawEvent, player))
+		}
+	default:
+		panic(InvalidMessage{"Unknown message type " + message.Type + "."})
+	}
+	return nil
+}
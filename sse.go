@@ -0,0 +1,170 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// Comment sent to keep idle event streams open.
+const keepAlive = 15 * time.Second
+
+// Last event ID from the header, or the query for clients that cannot set
+// headers.
+func lastEventID(r *http.Request) string {
+	if ID := r.Header.Get("Last-Event-ID"); ID != "" {
+		return ID
+	}
+	return r.URL.Query().Get("lastEventId")
+}
+
+type eventStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+func startEventStream(w http.ResponseWriter) eventStream {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		log.Panicln("Streaming unsupported.")
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return eventStream{w, flusher}
+}
+
+func (stream eventStream) send(ID string, event GameEventMessage) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(stream.w, "id: %s\nevent: %s\ndata: %s\n\n", ID, event.Type, data); err != nil {
+		return err
+	}
+	stream.flusher.Flush()
+	return nil
+}
+
+func (stream eventStream) ping() error {
+	if _, err := fmt.Fprint(stream.w, ": ping\n\n"); err != nil {
+		return err
+	}
+	stream.flusher.Flush()
+	return nil
+}
+
+// Stream game events as Server-Sent Events.
+//
+// Event IDs are game versions, a Last-Event-ID replays later events from the
+// move history.
+func serveEvents(w http.ResponseWriter, r *http.Request, ID uuid.UUID) {
+	sub := events.subscribe(ID)
+	defer events.unsubscribe(ID, sub)
+	game := GetGame(ID).(*boardModel)
+	since := game.Version
+	if last := lastEventID(r); last != "" {
+		version, err := strconv.Atoi(last)
+		if err != nil {
+			panic(InvalidMessage{"Invalid Last-Event-ID."})
+		}
+		since = version
+	}
+	stream := startEventStream(w)
+	for _, event := range game.replay(since) {
+		if stream.send(strconv.Itoa(event.ID), event) != nil {
+			return
+		}
+	}
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case event, ok := <-sub:
+			if !ok {
+				return
+			}
+			if event.ID <= game.Version && event.Type != drawEvent {
+				continue
+			}
+			if stream.send(strconv.Itoa(event.ID), event) != nil {
+				return
+			}
+		case <-ticker.C:
+			if stream.ping() != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// Games created after the game with ID.
+func gamesCreatedAfter(ID uuid.UUID) []boardModel {
+	db := openDB()
+	defer closeDB(db)
+	var last boardModel
+	if err := db.First(&last, "id = ?", ID).Error; err != nil {
+		panic(NoBoard{})
+	}
+	var games []boardModel
+	err := db.Where("created_at > ? OR (created_at = ? AND id > ?)", last.CreatedAt, last.CreatedAt, last.ID).
+		Order("created_at, id").Find(&games).Error
+	if err != nil {
+		log.Panicln(err)
+	}
+	return games
+}
+
+// Stream game creations as Server-Sent Events.
+//
+// Event IDs are game IDs, a Last-Event-ID replays games created after it.
+func serveGameFeed(w http.ResponseWriter, r *http.Request) {
+	sub := events.subscribe(uuid.UUID{})
+	defer events.unsubscribe(uuid.UUID{}, sub)
+	replayed := make(map[uuid.UUID]bool)
+	var missed []boardModel
+	if last := lastEventID(r); last != "" {
+		ID, err := uuid.FromString(last)
+		if err != nil {
+			panic(InvalidMessage{"Invalid Last-Event-ID."})
+		}
+		missed = gamesCreatedAfter(ID)
+	}
+	stream := startEventStream(w)
+	for _, game := range missed {
+		replayed[game.ID] = true
+		if stream.send(game.ID.String(), GameEventMessage{Game: game.ID, Type: createEvent, State: game.State}) != nil {
+			return
+		}
+	}
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case event, ok := <-sub:
+			if !ok {
+				return
+			}
+			if replayed[event.Game] {
+				continue
+			}
+			if stream.send(event.Game.String(), event) != nil {
+				return
+			}
+		case <-ticker.C:
+			if stream.ping() != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package models
+
+// Piece types, the index of a piece in the tables below.
+const (
+	bishopType = BISHOP / 2
+	kingType   = KING / 2
+	knightType = KNIGHT / 2
+	pawnType   = PAWN / 2
+	queenType  = QUEEN / 2
+	rookType   = ROOK / 2
+)
+
+// Material phase of each piece type, all pieces on the board make the
+// midgame.
+var phaseWeights = [7]int{bishopType: 1, knightType: 1, queenType: 4, rookType: 2}
+
+const midgamePhase = 24
+
+// Piece values by phase.
+var (
+	midgameValues = [7]int{bishopType: 330, knightType: 320, pawnType: 100, queenType: 900, rookType: 500}
+	endgameValues = [7]int{bishopType: 320, knightType: 300, pawnType: 120, queenType: 920, rookType: 520}
+)
+
+// Endgame squares that differ from the midgame ones, the king walks to the
+// centre and pawns gain with each rank.
+var (
+	endgameKingSquares = [8][8]int{
+		{-50, -40, -30, -20, -20, -30, -40, -50},
+		{-30, -20, -10, 0, 0, -10, -20, -30},
+		{-30, -10, 20, 30, 30, 20, -10, -30},
+		{-30, -10, 30, 40, 40, 30, -10, -30},
+		{-30, -10, 30, 40, 40, 30, -10, -30},
+		{-30, -10, 20, 30, 30, 20, -10, -30},
+		{-30, -30, 0, 0, 0, 0, -30, -30},
+		{-50, -30, -30, -30, -30, -30, -30, -50},
+	}
+	endgamePawnRanks = [8]int{0, 80, 50, 30, 15, 5, 0, 0}
+)
+
+// Bonus of a passed pawn by row, row 1 promotes next.
+var (
+	midgamePassedPawn = [8]int{0, 100, 60, 35, 20, 10, 5, 0}
+	endgamePassedPawn = [8]int{0, 200, 120, 70, 40, 20, 10, 0}
+)
+
+// Bonus of each move of a piece by type.
+var (
+	midgameMobility = [7]int{bishopType: 4, knightType: 4, queenType: 1, rookType: 2}
+	endgameMobility = [7]int{bishopType: 4, knightType: 4, queenType: 2, rookType: 4}
+)
+
+// Pawn structure, piece and king terms by phase, king safety counts in the
+// midgame only.
+const (
+	midgameDoubledPawn  = -5
+	endgameDoubledPawn  = -10
+	midgameIsolatedPawn = -10
+	endgameIsolatedPawn = -15
+	midgameBishopPair   = 30
+	endgameBishopPair   = 50
+	midgameRookOpen     = 20
+	endgameRookOpen     = 10
+	midgameRookHalfOpen = 10
+	endgameRookHalfOpen = 5
+	shieldNear          = 10
+	shieldFar           = 5
+	kingOpenFile        = -15
+)
+
+// Tapered evaluation between midgame and endgame tables by material phase.
+//
+// Each side is scored from its own view, with mobility, pawn structure,
+// bishop pair, rook file and king shelter terms.
+type taperedEvaluator struct {
+	midgame, endgame *[7][8][8]int
+}
+
+func newTaperedEvaluator() taperedEvaluator {
+	weights := balanceWeightAgent()
+	squares := [7][8][8]int{
+		bishopType: weights.OwnBISHOPSquares,
+		kingType:   weights.OwnKINGSquares,
+		knightType: weights.OwnKNIGHTSquares,
+		pawnType:   weights.OwnPAWNSquares,
+		queenType:  weights.OwnQUEENSquares,
+		rookType:   weights.OwnROOKSquares,
+	}
+	var midgame, endgame [7][8][8]int
+	for kind := range squares {
+		for posY := range squares[kind] {
+			for posX := range squares[kind][posY] {
+				midgame[kind][posY][posX] = midgameValues[kind] + squares[kind][posY][posX]
+				endgame[kind][posY][posX] = endgameValues[kind] + squares[kind][posY][posX]
+			}
+		}
+	}
+	endgame[kingType] = endgameKingSquares
+	for posY := range endgame[pawnType] {
+		for posX := range endgame[pawnType][posY] {
+			endgame[pawnType][posY][posX] = endgameValues[pawnType] + endgamePawnRanks[posY]
+		}
+	}
+	return taperedEvaluator{&midgame, &endgame}
+}
+
+// Terms of the active player and its material phase.
+func (evaluator taperedEvaluator) side(b board) (midgame int, endgame int, phase int) {
+	var ownPawns, oppPawns [8]int
+	// Row of the least advanced opponent pawn on each file, the one nearest
+	// the opponent side that an own pawn would still have to pass.
+	oppFront := [8]int{8, 8, 8, 8, 8, 8, 8, 8}
+	for posY, r := range b {
+		for posX, piece := range r {
+			switch piece & 0xF {
+			case PAWN | 1:
+				ownPawns[posX]++
+			case PAWN:
+				oppPawns[posX]++
+				if posY < oppFront[posX] {
+					oppFront[posX] = posY
+				}
+			}
+		}
+	}
+	bishops := 0
+	for _, p := range activePieces(b) {
+		kind := p.piece & 0xE / 2
+		posX, posY := int(p.posX), int(p.posY)
+		midgame += evaluator.midgame[kind][posY][posX]
+		endgame += evaluator.endgame[kind][posY][posX]
+		phase += phaseWeights[kind]
+		switch kind {
+		case pawnType:
+			if ownPawns[posX] > 1 {
+				midgame += midgameDoubledPawn
+				endgame += endgameDoubledPawn
+			}
+			if (posX == 0 || ownPawns[posX-1] == 0) && (posX == 7 || ownPawns[posX+1] == 0) {
+				midgame += midgameIsolatedPawn
+				endgame += endgameIsolatedPawn
+			}
+			passed := true
+			for file := posX - 1; file <= posX+1; file++ {
+				if file >= 0 && file < 8 && oppFront[file] < posY {
+					passed = false
+				}
+			}
+			if passed {
+				midgame += midgamePassedPawn[posY]
+				endgame += endgamePassedPawn[posY]
+			}
+		case kingType:
+			for file := posX - 1; file <= posX+1; file++ {
+				if file < 0 || file > 7 {
+					continue
+				}
+				switch {
+				case posY > 0 && b[posY-1][file]&0xF == PAWN|1:
+					midgame += shieldNear
+				case posY > 1 && b[posY-2][file]&0xF == PAWN|1:
+					midgame += shieldFar
+				case ownPawns[file] == 0:
+					midgame += kingOpenFile
+				}
+			}
+		case rookType:
+			switch {
+			case ownPawns[posX] == 0 && oppPawns[posX] == 0:
+				midgame += midgameRookOpen
+				endgame += endgameRookOpen
+			case ownPawns[posX] == 0:
+				midgame += midgameRookHalfOpen
+				endgame += endgameRookHalfOpen
+			}
+		case bishopType:
+			bishops++
+		}
+		if midgameMobility[kind] != 0 {
+			moves := len(validMovesForPiece(b, p.piece, p.posX, p.posY))
+			midgame += midgameMobility[kind] * moves
+			endgame += endgameMobility[kind] * moves
+		}
+	}
+	if bishops >= 2 {
+		midgame += midgameBishopPair
+		endgame += endgameBishopPair
+	}
+	return midgame, endgame, phase
+}
+
+// Value of a board for the active player.
+func (evaluator taperedEvaluator) evaluate(b board) int {
+	ownMidgame, ownEndgame, ownPhase := evaluator.side(b)
+	oppMidgame, oppEndgame, oppPhase := evaluator.side(swap(b))
+	phase := ownPhase + oppPhase
+	if phase > midgamePhase {
+		phase = midgamePhase
+	}
+	midgame := ownMidgame - oppMidgame
+	endgame := ownEndgame - oppEndgame
+	return (midgame*phase + endgame*(midgamePhase-phase)) / midgamePhase
+}
@@ -0,0 +1,241 @@
+package models
+
+import (
+	"container/list"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// Zobrist keys for each piece, with its castling flag, on each square.
+var zobrist = func() (keys [32][8][8]uint64) {
+	rng := rand.New(rand.NewSource(0x6E6B))
+	for piece := range keys {
+		for posY := range keys[piece] {
+			for posX := range keys[piece][posY] {
+				keys[piece][posY][posX] = rng.Uint64()
+			}
+		}
+	}
+	return keys
+}()
+
+// Position hash of a board from the active player view.
+func hashBoard(b board) uint64 {
+	var key uint64
+	for posY, r := range b {
+		for posX, piece := range r {
+			if piece != 0 {
+				key ^= zobrist[piece&0x1F][posY][posX]
+			}
+		}
+	}
+	return key
+}
+
+// Bound types of a stored score.
+const (
+	exactBound uint8 = iota
+	lowerBound
+	upperBound
+)
+
+// Searched position, best is the hash of the best next board.
+type tableEntry struct {
+	key   uint64
+	best  uint64
+	score int32
+	depth int8
+	bound uint8
+}
+
+// Shards of the transposition table, each with its own lock.
+const tableShards = 64
+
+type tableShard struct {
+	sync.Mutex
+	entries []tableEntry
+}
+
+// Default and largest transposition table sizes of an agent, and the most
+// all agent tables may take together, in megabytes.
+const (
+	defaultTableSize = 16
+	maxTableSize     = 256
+	maxTableMemory   = 1024
+)
+
+// Fixed size transposition table shared by concurrent searches.
+//
+// Entries are allocated on first use.
+type transpositionTable struct {
+	probes     int64
+	hits       int64
+	stores     int64
+	collisions int64
+	size       int
+	once       sync.Once
+	shards     [tableShards]tableShard
+}
+
+// TableStatsMessage search.
+//
+// Size is in megabytes. Collisions count stores that replaced another
+// position.
+type TableStatsMessage struct {
+	Size, Entries                    int
+	Probes, Hits, Stores, Collisions int64
+}
+
+// Entries of each shard.
+func (table *transpositionTable) shardEntries() int {
+	entries := table.size << 20 / int(unsafe.Sizeof(tableEntry{})) / tableShards
+	if entries < 1 {
+		entries = 1
+	}
+	return entries
+}
+
+func (table *transpositionTable) allocate() {
+	entries := table.shardEntries()
+	for i := range table.shards {
+		table.shards[i].entries = make([]tableEntry, entries)
+	}
+}
+
+// Slot of a key.
+func (table *transpositionTable) slot(key uint64) (*tableShard, int) {
+	table.once.Do(table.allocate)
+	shard := &table.shards[key%tableShards]
+	return shard, int(key / tableShards % uint64(len(shard.entries)))
+}
+
+func (table *transpositionTable) probe(key uint64) (tableEntry, bool) {
+	atomic.AddInt64(&table.probes, 1)
+	shard, i := table.slot(key)
+	shard.Lock()
+	entry := shard.entries[i]
+	shard.Unlock()
+	if entry.key != key {
+		return tableEntry{}, false
+	}
+	atomic.AddInt64(&table.hits, 1)
+	return entry, true
+}
+
+// Store an entry unless a deeper search of the same position is stored.
+func (table *transpositionTable) store(entry tableEntry) {
+	atomic.AddInt64(&table.stores, 1)
+	shard, i := table.slot(entry.key)
+	shard.Lock()
+	defer shard.Unlock()
+	old := shard.entries[i]
+	if old.key == entry.key && old.depth > entry.depth {
+		return
+	}
+	if old.key != 0 && old.key != entry.key {
+		atomic.AddInt64(&table.collisions, 1)
+	}
+	shard.entries[i] = entry
+}
+
+// Statistics of the table, without allocating it.
+func (table *transpositionTable) stats() TableStatsMessage {
+	return TableStatsMessage{
+		Size:       table.size,
+		Entries:    table.shardEntries() * tableShards,
+		Probes:     atomic.LoadInt64(&table.probes),
+		Hits:       atomic.LoadInt64(&table.hits),
+		Stores:     atomic.LoadInt64(&table.stores),
+		Collisions: atomic.LoadInt64(&table.collisions),
+	}
+}
+
+// Table size in megabytes, zero for the default size.
+func tableSize(size int) int {
+	if size <= 0 {
+		return defaultTableSize
+	}
+	return size
+}
+
+// Transposition tables of agents, least recently used first.
+//
+// Scores depend on the agent evaluation so every agent has its own table.
+// The least recently used tables are dropped once together they may take
+// more than maxTableMemory.
+type tableCache struct {
+	sync.Mutex
+	byAgent map[uuid.UUID]*list.Element
+	order   *list.List
+	memory  int
+}
+
+type agentTable struct {
+	ID    uuid.UUID
+	table *transpositionTable
+}
+
+var tables = tableCache{byAgent: make(map[uuid.UUID]*list.Element), order: list.New()}
+
+func (cache *tableCache) remove(element *list.Element) {
+	entry := cache.order.Remove(element).(agentTable)
+	delete(cache.byAgent, entry.ID)
+	cache.memory -= entry.table.size
+}
+
+// Transposition table of an agent with a size in megabytes.
+func tableForAgent(ID uuid.UUID, size int) *transpositionTable {
+	size = tableSize(size)
+	tables.Lock()
+	defer tables.Unlock()
+	if element, ok := tables.byAgent[ID]; ok {
+		if table := element.Value.(agentTable).table; table.size == size {
+			tables.order.MoveToBack(element)
+			return table
+		}
+		tables.remove(element)
+	}
+	table := &transpositionTable{size: size}
+	tables.byAgent[ID] = tables.order.PushBack(agentTable{ID, table})
+	tables.memory += size
+	for tables.memory > maxTableMemory {
+		tables.remove(tables.order.Front())
+	}
+	return table
+}
+
+// Statistics of the table of an agent, empty until the agent searches.
+func agentTableStats(ID uuid.UUID, size int) TableStatsMessage {
+	tables.Lock()
+	defer tables.Unlock()
+	if element, ok := tables.byAgent[ID]; ok {
+		return element.Value.(agentTable).table.stats()
+	}
+	return (&transpositionTable{size: tableSize(size)}).stats()
+}
+
+// Mate scores are stored relative to the position, not the root.
+func scoreToTable(score int, ply int) int32 {
+	switch {
+	case score > mateScore-maxSearchDepth*2:
+		score += ply
+	case score < -mateScore+maxSearchDepth*2:
+		score -= ply
+	}
+	return int32(score)
+}
+
+func scoreFromTable(score int32, ply int) int {
+	out := int(score)
+	switch {
+	case out > mateScore-maxSearchDepth*2:
+		out -= ply
+	case out < -mateScore+maxSearchDepth*2:
+		out += ply
+	}
+	return out
+}
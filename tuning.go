@@ -0,0 +1,396 @@
+package models
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// TuningPosition with the game result for the active player, 1 for a win,
+// 0.5 for a draw and 0 for a loss.
+type TuningPosition struct {
+	state  board
+	result float64
+}
+
+// FEN letters of the pieces, white pieces are upper case.
+var fenPieces = map[rune]uint8{
+	'b': BISHOP, 'k': KING, 'n': KNIGHT, 'p': PAWN, 'q': QUEEN, 'r': ROOK,
+	'B': BISHOP | 1, 'K': KING | 1, 'N': KNIGHT | 1, 'P': PAWN | 1, 'Q': QUEEN | 1, 'R': ROOK | 1,
+}
+
+// Castling rights by FEN letter, the king and rook squares from the white
+// view.
+var fenCastling = map[rune][2][2]int{
+	'K': {{7, 4}, {7, 7}},
+	'Q': {{7, 4}, {7, 0}},
+	'k': {{0, 4}, {0, 7}},
+	'q': {{0, 4}, {0, 0}},
+}
+
+// Board from FEN piece placement, side to move and castling rights, from
+// the active player view.
+func parseFEN(placement string, side string, castling string) (board, error) {
+	var out board
+	rows := strings.Split(placement, "/")
+	if len(rows) != 8 {
+		return out, fmt.Errorf("placement has %d rows, want 8", len(rows))
+	}
+	for posY, row := range rows {
+		posX := 0
+		for _, letter := range row {
+			if letter >= '1' && letter <= '8' {
+				posX += int(letter - '0')
+				continue
+			}
+			piece, ok := fenPieces[letter]
+			if !ok || posX > 7 {
+				return out, fmt.Errorf("invalid row %q", row)
+			}
+			out[posY][posX] = piece
+			posX++
+		}
+		if posX != 8 {
+			return out, fmt.Errorf("row %q has %d squares, want 8", row, posX)
+		}
+	}
+	if castling != "-" {
+		for _, letter := range castling {
+			squares, ok := fenCastling[letter]
+			if !ok {
+				return out, fmt.Errorf("invalid castling rights %q", castling)
+			}
+			for _, square := range squares {
+				if out[square[0]][square[1]]&0xE == 0 {
+					return out, fmt.Errorf("castling rights %q without the pieces", castling)
+				}
+				out[square[0]][square[1]] |= 0x10
+			}
+		}
+	}
+	switch side {
+	case "w":
+		return out, nil
+	case "b":
+		return swap(out), nil
+	}
+	return out, fmt.Errorf("invalid side to move %q", side)
+}
+
+// Result for white of an EPD c9 operation or a bracketed score.
+func parseResult(operations string) (float64, bool) {
+	switch {
+	case strings.Contains(operations, "1/2-1/2"), strings.Contains(operations, "[0.5]"):
+		return 0.5, true
+	case strings.Contains(operations, "1-0"), strings.Contains(operations, "[1.0]"):
+		return 1, true
+	case strings.Contains(operations, "0-1"), strings.Contains(operations, "[0.0]"):
+		return 0, true
+	}
+	return 0, false
+}
+
+// ReadEPD positions labelled with their game result.
+//
+// Each line holds a FEN placement, side to move and castling rights followed
+// by the result as c9 "1-0" or [1.0]. Blank lines and lines starting with #
+// are skipped.
+func ReadEPD(r io.Reader) ([]TuningPosition, error) {
+	var out []TuningPosition
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("line %d: expected placement, side, castling and result", line)
+		}
+		state, err := parseFEN(fields[0], fields[1], fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		result, ok := parseResult(strings.Join(fields[3:], " "))
+		if !ok {
+			return nil, fmt.Errorf("line %d: no result", line)
+		}
+		if fields[1] == "b" {
+			result = 1 - result
+		}
+		out = append(out, TuningPosition{state, result})
+	}
+	return out, scanner.Err()
+}
+
+// HistoryPositions of finished games, skipping the opening moves of each.
+func HistoryPositions(skip int) []TuningPosition {
+	db := openDB()
+	defer closeDB(db)
+	var games []boardModel
+	if err := db.Where("end_reason != ''").Find(&games).Error; err != nil {
+		log.Panicln(err)
+	}
+	var out []TuningPosition
+	for _, game := range games {
+		white, ok := game.whiteScore()
+		if !ok {
+			continue
+		}
+		var moves []moveModel
+		if err := db.Where("game_id = ? AND move_count > ?", game.ID, skip).Order("version").Find(&moves).Error; err != nil {
+			log.Panicln(err)
+		}
+		for _, move := range moves {
+			result := white
+			if move.MoveCount%2 != 0 {
+				result = 1 - white
+			}
+			out = append(out, TuningPosition{move.State, result})
+		}
+	}
+	return out
+}
+
+// TuningOptions of TuneProfile.
+//
+// Passes caps the passes over every parameter, zero runs until a pass makes
+// no progress. Step is the change tried on each parameter, 1 by default.
+type TuningOptions struct {
+	Passes int
+	Step   int
+	// Progress is called after each pass when set.
+	Progress func(pass int, err float64)
+}
+
+// TuningResult of TuneProfile.
+//
+// K scales evaluations into expected scores, the errors are the mean
+// squared error of the expected scores before and after tuning.
+type TuningResult struct {
+	K                        float64
+	InitialError, FinalError float64
+	Passes                   int
+}
+
+// Index of each profile parameter, piece values then square tables.
+func tuningParameters(profile EvaluationProfile) []int {
+	keys := profileKeys()
+	params := make([]int, 0, len(keys)-1+len(keys)*64)
+	for _, key := range keys[:len(keys)-1] {
+		params = append(params, profile.Values[key])
+	}
+	for _, key := range keys {
+		for _, row := range profile.Squares[key] {
+			params = append(params, row...)
+		}
+	}
+	return params
+}
+
+func tunedProfile(profile EvaluationProfile, params []int) EvaluationProfile {
+	keys := profileKeys()
+	out := EvaluationProfile{profile.Name, profile.Agent, profile.Evaluator, make(map[string]int), make(map[string][][]int)}
+	for i, key := range keys[:len(keys)-1] {
+		out.Values[key] = params[i]
+	}
+	squares := params[len(keys)-1:]
+	for i, key := range keys {
+		rows := make([][]int, 8)
+		for posY := range rows {
+			start := i*64 + posY*8
+			rows[posY] = append([]int(nil), squares[start:start+8]...)
+		}
+		out.Squares[key] = rows
+	}
+	return out
+}
+
+// Index into profilePieces by piece type.
+var pieceProfileIndex = [7]int{bishopType: 2, kingType: 5, knightType: 1, pawnType: 0, queenType: 4, rookType: 3}
+
+// Parameter index of a piece value and of its square table, the value is
+// negative for an empty square.
+func featureIndex(piece uint8, posY int, posX int) (int, int) {
+	key := len(profilePieces) * 2
+	if piece&0xE != 0 {
+		key = pieceProfileIndex[piece&0xE/2]
+		if piece&1 == 0 {
+			key += len(profilePieces)
+		}
+	}
+	square := len(profilePieces)*2 + key*64 + posY*8 + posX
+	if key == len(profilePieces)*2 {
+		return -1, square
+	}
+	return key, square
+}
+
+// Weight of a parameter in an evaluation.
+type tuningFeature struct {
+	param int
+	count int
+}
+
+// Features of the profile evaluation of a board for the active player, the
+// weighted board less the weighted board of the opponent as searchAgent
+// evaluates it.
+func tuningFeatures(b board) []tuningFeature {
+	counts := make(map[int]int)
+	for i, view := range [2]board{b, swap(b)} {
+		sign := 1 - 2*i
+		for posY, r := range view {
+			for posX, piece := range r {
+				value, square := featureIndex(piece&0xF, posY, posX)
+				if value >= 0 {
+					counts[value] += sign
+				}
+				counts[square] += sign
+			}
+		}
+	}
+	out := make([]tuningFeature, 0, len(counts))
+	for param, count := range counts {
+		if count != 0 {
+			out = append(out, tuningFeature{param, count})
+		}
+	}
+	return out
+}
+
+// Position with the count of a parameter in its evaluation.
+type tuningUse struct {
+	position int
+	count    int
+}
+
+// Expected score of an evaluation.
+func expectedResult(k float64, score int) float64 {
+	return 1 / (1 + math.Exp(-k*float64(score)))
+}
+
+// Texel tuning state, the evaluation of each position kept up to date.
+type tuner struct {
+	positions []TuningPosition
+	scores    []int
+	// Positions and their feature counts by parameter.
+	uses [][]tuningUse
+	k    float64
+}
+
+func newTuner(positions []TuningPosition, params []int) *tuner {
+	t := &tuner{positions: positions, scores: make([]int, len(positions)), uses: make([][]tuningUse, len(params))}
+	for i, position := range positions {
+		for _, feature := range tuningFeatures(position.state) {
+			t.scores[i] += feature.count * params[feature.param]
+			t.uses[feature.param] = append(t.uses[feature.param], tuningUse{i, feature.count})
+		}
+	}
+	return t
+}
+
+// Mean squared error of the expected scores with k.
+func (t *tuner) meanError(k float64) float64 {
+	sum := 0.0
+	for i, position := range t.positions {
+		diff := position.result - expectedResult(k, t.scores[i])
+		sum += diff * diff
+	}
+	return sum / float64(len(t.positions))
+}
+
+// Fit k on a log scale by golden section search.
+func (t *tuner) fitK() {
+	low, high := -9.0, 1.0
+	ratio := (math.Sqrt(5) - 1) / 2
+	for high-low > 1e-4 {
+		a := high - ratio*(high-low)
+		b := low + ratio*(high-low)
+		if t.meanError(math.Pow(10, a)) < t.meanError(math.Pow(10, b)) {
+			high = b
+		} else {
+			low = a
+		}
+	}
+	t.k = math.Pow(10, (low+high)/2)
+}
+
+// Change of the error sum when a parameter changes by delta.
+func (t *tuner) errorChange(param int, delta int) float64 {
+	change := 0.0
+	for _, use := range t.uses[param] {
+		result := t.positions[use.position].result
+		before := result - expectedResult(t.k, t.scores[use.position])
+		after := result - expectedResult(t.k, t.scores[use.position]+use.count*delta)
+		change += after*after - before*before
+	}
+	return change
+}
+
+func (t *tuner) apply(param int, delta int) {
+	for _, use := range t.uses[param] {
+		t.scores[use.position] += use.count * delta
+	}
+}
+
+// TuneProfile fits the profile weights to game results.
+//
+// The profile is evaluated as the search agent evaluates it, scaled into an
+// expected score with a fitted K. Each pass tries every parameter up and
+// down by the step and keeps changes that lower the error.
+func TuneProfile(profile EvaluationProfile, positions []TuningPosition, options TuningOptions) (EvaluationProfile, TuningResult, error) {
+	if _, err := profile.weightAgent(); err != nil {
+		return profile, TuningResult{}, err
+	}
+	if len(positions) == 0 {
+		return profile, TuningResult{}, errors.New("no positions to tune on")
+	}
+	if options.Step <= 0 {
+		options.Step = 1
+	}
+	params := tuningParameters(profile)
+	t := newTuner(positions, params)
+	t.fitK()
+	result := TuningResult{K: t.k, InitialError: t.meanError(t.k)}
+	for options.Passes == 0 || result.Passes < options.Passes {
+		improved := false
+		for param := range params {
+			if len(t.uses[param]) == 0 {
+				continue
+			}
+			for _, delta := range []int{options.Step, -options.Step} {
+				if t.errorChange(param, delta) < 0 {
+					params[param] += delta
+					t.apply(param, delta)
+					improved = true
+					break
+				}
+			}
+		}
+		result.Passes++
+		if options.Progress != nil {
+			options.Progress(result.Passes, t.meanError(t.k))
+		}
+		if !improved {
+			break
+		}
+	}
+	result.FinalError = t.meanError(t.k)
+	return tunedProfile(profile, params), result, nil
+}
+
+// BuiltinProfile of the positive or balance weights.
+func BuiltinProfile(name string) (EvaluationProfile, error) {
+	switch name {
+	case "positive":
+		return positiveWeightAgent().profile(name, ""), nil
+	case "balance":
+		return balanceWeightAgent().profile(name, ""), nil
+	}
+	return EvaluationProfile{}, fmt.Errorf("no built in profile %s", name)
+}